@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+const checkpointSaveFreq = 5 * time.Second
+
+// checkpointStore keeps track, for every tailed target, of the last event written out,
+// and persists it to a file so that a following run can resume from there.
+type checkpointStore struct {
+	path string
+	log  *log.Logger
+	sync.Mutex
+	Targets map[string]*cloudwatch.Checkpoint `json:"targets"`
+	dirty   bool
+	// flush, when set, makes the recorded events durable before the checkpoints are saved
+	flush func() error
+}
+
+// loadCheckpoints reads the checkpoints file at path. A missing file is not an error, it results in an empty store.
+func loadCheckpoints(path string, log *log.Logger) (*checkpointStore, error) {
+	store := &checkpointStore{path: path, log: log, Targets: make(map[string]*cloudwatch.Checkpoint)}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, store); err != nil {
+		return nil, err
+	}
+	if store.Targets == nil {
+		store.Targets = make(map[string]*cloudwatch.Checkpoint)
+	}
+	return store, nil
+}
+
+func (c *checkpointStore) get(target string) *cloudwatch.Checkpoint {
+	c.Lock()
+	defer c.Unlock()
+	cp, ok := c.Targets[target]
+	if !ok {
+		return nil
+	}
+	return &cloudwatch.Checkpoint{Timestamp: cp.Timestamp, EventIDs: append([]string(nil), cp.EventIDs...)}
+}
+
+// record moves the checkpoint of target forward to the given event.
+func (c *checkpointStore) record(target string, ev types.FilteredLogEvent) {
+	if ev.Timestamp == nil || ev.EventId == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	cp, ok := c.Targets[target]
+	switch {
+	case !ok || *ev.Timestamp > cp.Timestamp:
+		c.Targets[target] = &cloudwatch.Checkpoint{Timestamp: *ev.Timestamp, EventIDs: []string{*ev.EventId}}
+	case *ev.Timestamp == cp.Timestamp:
+		cp.EventIDs = append(cp.EventIDs, *ev.EventId)
+	default:
+		// late event older than the checkpoint: the checkpoint already covers it
+		return
+	}
+	c.dirty = true
+}
+
+// save writes the checkpoints to file, replacing the previous content atomically.
+func (c *checkpointStore) save() error {
+	c.Lock()
+	defer c.Unlock()
+	// flushing while holding the lock: no event can be recorded before it is flushed
+	if c.flush != nil {
+		if err := c.flush(); err != nil {
+			return err
+		}
+	}
+	if !c.dirty {
+		return nil
+	}
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(c.path, b); err != nil {
+		return err
+	}
+	c.dirty = false
+	c.log.Printf("checkpoint: saved %d targets to %s\n", len(c.Targets), c.path)
+	return nil
+}
+
+// writeFileAtomic replaces the content of the file at path with b, through a temporary file renamed over it.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// run saves the checkpoints every interval until ctx is cancelled.
+// Failures are reported on w, once until the error changes or a save succeeds.
+func (c *checkpointStore) run(ctx context.Context, interval time.Duration, w io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var failure string
+	for {
+		select {
+		case <-ticker.C:
+			err := c.save()
+			if err == nil {
+				failure = ""
+				continue
+			}
+			c.log.Println("checkpoint: save failed:", err)
+			if err.Error() != failure {
+				failure = err.Error()
+				fmt.Fprintln(w, color.RedString("cw: can't save the checkpoints to %s: %v", c.path, err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
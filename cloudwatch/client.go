@@ -13,7 +13,7 @@ import (
 )
 
 // New creates a new instance of the cloudwatchlogs client
-func New(awsEndpointURL *string, awsProfile *string, awsRegion *string, log *log.Logger) *cloudwatchlogs.Client {
+func New(ctx context.Context, awsEndpointURL *string, awsProfile *string, awsRegion *string, log *log.Logger) (*cloudwatchlogs.Client, error) {
 	//workaround to figure out the user actual home dir within a SNAP (rather than the sandboxed one)
 	//and access the  .aws folder in its default location
 	if os.Getenv("SNAP_INSTANCE_NAME") != "" {
@@ -57,10 +57,10 @@ func New(awsEndpointURL *string, awsProfile *string, awsRegion *string, log *log
 		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
 	})
 
-	cfg, err := config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(profile),
+	cfg, err := config.LoadDefaultConfig(ctx, config.WithSharedConfigProfile(profile),
 		config.WithEndpointResolverWithOptions(customResolver), config.WithRegion(region))
 	if err != nil {
-		os.Exit(1)
+		return nil, err
 	}
-	return cloudwatchlogs.NewFromConfig(cfg)
+	return cloudwatchlogs.NewFromConfig(cfg), nil
 }
@@ -48,7 +48,7 @@ func TestLsStreams(t *testing.T) {
 	}
 	ch := make(chan types.LogStream)
 	errCh := make(chan error)
-	go getStreams(pag, errCh, ch)
+	go getStreams(context.Background(), pag, errCh, ch)
 
 	for l := range ch {
 		assert.Contains(t, streams, l)
@@ -69,7 +69,7 @@ func TestTailShouldFailIfNoStreamsAdNoRetry(t *testing.T) {
 	}
 	retry := false
 	debugLog := log.New(io.Discard, "cw [debug] ", log.LstdFlags)
-	err := initialiseStreams(&retry, idleCh, nil, fetchStreams, debugLog)
+	err := initialiseStreams(context.Background(), &retry, idleCh, nil, fetchStreams, nil, debugLog)
 
 	assert.Error(t, err)
 }
@@ -101,7 +101,7 @@ func TestTailWaitForStreamsWithRetry(t *testing.T) {
 	retry := true
 	logStreams := &logStreamsType{}
 	debugLog := log.New(io.Discard, "cw [debug] ", log.LstdFlags)
-	err := initialiseStreams(&retry, idleCh, logStreams, fetchStreams, debugLog)
+	err := initialiseStreams(context.Background(), &retry, idleCh, logStreams, fetchStreams, nil, debugLog)
 
 	assert.Nil(t, err)
 	assert.Len(t, logStreams.get(), 2)
@@ -114,20 +114,18 @@ func TestTailWaitForStreamsWithRetry(t *testing.T) {
 	}
 }
 
-func TestShortenLogStreamsListIfTooLong(t *testing.T) {
-
-	var streams = []types.LogStream{}
-
-	size := 105
-	for i := 0; i < size; i++ {
-		name := fmt.Sprintf("streams%d", i)
-		x := &types.LogStream{LogStreamName: aws.String(name)}
-		streams = append(streams, *x)
+func TestShardStreams(t *testing.T) {
+	var names []string
+	for i := 0; i < 205; i++ {
+		names = append(names, fmt.Sprintf("streams%d", i))
 	}
 
-	assert.Len(t, streams, size)
-	streams = sortLogStreamsByMostRecentEvent(streams)
-	assert.Len(t, streams, 100)
+	shards := shardStreams(names)
+	assert.Len(t, shards, 3)
+	assert.Len(t, shards[0], 100)
+	assert.Len(t, shards[1], 100)
+	assert.Equal(t, []string{"streams200", "streams201", "streams202", "streams203", "streams204"}, shards[2])
+	assert.Len(t, shardStreams(names[:100]), 1)
 }
 
 func TestSortLogStreamsByMostRecentEvent(t *testing.T) {
@@ -147,12 +145,51 @@ func TestSortLogStreamsByMostRecentEvent(t *testing.T) {
 	assert.Greater(t, *first.LastIngestionTime, *last.LastIngestionTime)
 	streams = sortLogStreamsByMostRecentEvent(streams)
 
-	// eventTimestamp := *s.LastEventTimestamp / 1000
-	// ts := time.Unix(eventTimestamp, 0).Format(timeFormat)
-
-	assert.Len(t, streams, 100)
-	assert.Equal(t, *streams[len(streams)-1].LogStreamName, "stream0")
+	assert.Len(t, streams, size)
+	assert.Equal(t, *streams[0].LogStreamName, "stream0")
 	first = streams[0]
 	last = streams[len(streams)-1]
-	assert.Less(t, *first.LastIngestionTime, *last.LastIngestionTime)
+	assert.Greater(t, *first.LastIngestionTime, *last.LastIngestionTime)
+}
+
+func TestInitialiseStreamsStopsRetryingWhenCancelled(t *testing.T) {
+	fetchStreams := func() (<-chan types.LogStream, <-chan error) {
+		ch := make(chan types.LogStream)
+		errCh := make(chan error, 1)
+		errCh <- &types.ResourceNotFoundException{Message: new(string)}
+		return ch, errCh
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	retry := true
+	debugLog := log.New(io.Discard, "cw [debug] ", log.LstdFlags)
+	err := initialiseStreams(ctx, &retry, make(chan bool, 1), &logStreamsType{}, fetchStreams, nil, debugLog)
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestLsStreamsClosesChannelsOnCancel(t *testing.T) {
+	pag := &MockPager{PageNum: 0,
+		Pages: []*cloudwatchlogs.DescribeLogStreamsOutput{{LogStreams: streams}},
+	}
+	ch := make(chan types.LogStream)
+	errCh := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	go getStreams(ctx, pag, errCh, ch)
+
+	done := make(chan bool)
+	go func() {
+		for range ch {
+		}
+		for range errCh {
+		}
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "channels not closed after cancellation")
+	}
 }
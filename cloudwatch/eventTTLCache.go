@@ -1,6 +1,7 @@
 package cloudwatch
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
@@ -14,7 +15,7 @@ type eventCache struct {
 	sync.RWMutex
 }
 
-func createCache(ttl time.Duration, purgeFreq time.Duration, log *log.Logger) *eventCache {
+func createCache(ctx context.Context, ttl time.Duration, purgeFreq time.Duration, log *log.Logger) *eventCache {
 	if purgeFreq == 0 {
 		purgeFreq = defaultPurgeFreq
 	}
@@ -24,7 +25,13 @@ func createCache(ttl time.Duration, purgeFreq time.Duration, log *log.Logger) *e
 
 	janitor := func(c *eventCache, ttl time.Duration, freq time.Duration) {
 		cacheTicker := time.NewTicker(purgeFreq)
-		for range cacheTicker.C {
+		defer cacheTicker.Stop()
+		for {
+			select {
+			case <-cacheTicker.C:
+			case <-ctx.Done():
+				return
+			}
 			c.Lock()
 
 			var ids []string
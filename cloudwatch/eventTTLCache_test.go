@@ -1,6 +1,7 @@
 package cloudwatch
 
 import (
+	"context"
 	"io"
 	"log"
 	"testing"
@@ -16,7 +17,7 @@ func TestCache(t *testing.T) {
 	l := log.New(io.Discard, "", log.LstdFlags)
 
 	a := assert.New(t)
-	cache := createCache(ttl, purgeFreq, l)
+	cache := createCache(context.Background(), ttl, purgeFreq, l)
 	cache.Add("1", 1)
 	cache.Add("2", 2)
 	cache.Add("3", 3)
@@ -0,0 +1,639 @@
+// Package fake provides an in-memory CloudWatch Logs backend to exercise the cloudwatch package without AWS
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/smithy-go"
+)
+
+const defaultPageSize = 50
+
+// Event is a log event stored in the backend. Timestamps are in milliseconds since the epoch.
+// When IngestionTime is zero the time the event is added is used.
+type Event struct {
+	Timestamp     int64
+	IngestionTime int64
+	Message       string
+}
+
+type stream struct {
+	name    string
+	created int64
+	events  []types.FilteredLogEvent
+}
+
+type group struct {
+	name      string
+	created   int64
+	streams   map[string]*stream
+	retention int32
+	tags      map[string]string
+	filters   map[string]types.MetricFilter
+}
+
+// Backend is an in-memory CloudWatch Logs. It is safe for concurrent use.
+type Backend struct {
+	// PageSize is the maximum number of items returned in a single page
+	PageSize int
+	// Now returns the current time, time.Now is used when nil
+	Now func() time.Time
+
+	mu       sync.Mutex
+	groups   map[string]*group
+	nextID   int64
+	throttle int
+	calls    map[string]int
+	sessions map[*liveSession]bool
+}
+
+// New creates an empty backend
+func New() *Backend {
+	return &Backend{PageSize: defaultPageSize, groups: make(map[string]*group), calls: make(map[string]int), sessions: make(map[*liveSession]bool)}
+}
+
+func (b *Backend) now() int64 {
+	if b.Now != nil {
+		return b.Now().UnixNano() / int64(time.Millisecond)
+	}
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+func (b *Backend) pageSize() int {
+	if b.PageSize <= 0 {
+		return defaultPageSize
+	}
+	return b.PageSize
+}
+
+func (b *Backend) group(name string) *group {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &group{name: name, created: b.now(), streams: make(map[string]*stream)}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// AddGroup creates a log group if it does not exist yet
+func (b *Backend) AddGroup(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.group(name)
+}
+
+// AddStream creates a log stream, and its log group, if they do not exist yet
+func (b *Backend) AddStream(groupName, streamName string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stream(groupName, streamName)
+}
+
+func (b *Backend) stream(groupName, streamName string) *stream {
+	g := b.group(groupName)
+	s, ok := g.streams[streamName]
+	if !ok {
+		s = &stream{name: streamName, created: b.now()}
+		g.streams[streamName] = s
+	}
+	return s
+}
+
+// AddEvents appends events to a log stream, creating the stream and the group if needed
+func (b *Backend) AddEvents(groupName, streamName string, events ...Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.addEvents(groupName, streamName, events)
+}
+
+// addEvents appends events to a log stream and publishes them to the live sessions. It must be called with the lock held.
+func (b *Backend) addEvents(groupName, streamName string, events []Event) {
+	s := b.stream(groupName, streamName)
+	added := len(s.events)
+	for _, e := range events {
+		b.nextID++
+		ingestion := e.IngestionTime
+		if ingestion == 0 {
+			ingestion = b.now()
+		}
+		s.events = append(s.events, types.FilteredLogEvent{
+			EventId:       aws.String(fmt.Sprintf("%020d", b.nextID)),
+			LogStreamName: aws.String(streamName),
+			Timestamp:     aws.Int64(e.Timestamp),
+			IngestionTime: aws.Int64(ingestion),
+			Message:       aws.String(e.Message),
+		})
+	}
+	for session := range b.sessions {
+		session.publish(groupName, s.events[added:])
+	}
+}
+
+// Throttle makes the next n API calls fail with a ThrottlingException
+func (b *Backend) Throttle(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.throttle = n
+}
+
+// Calls returns the number of times the given operation, e.g. "FilterLogEvents", has been called
+func (b *Backend) Calls(op string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.calls[op]
+}
+
+// call records an API call and returns the injected error, if any. It must be called with the lock held.
+func (b *Backend) call(op string) error {
+	b.calls[op]++
+	if b.throttle > 0 {
+		b.throttle--
+		return &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded", Fault: smithy.FaultClient}
+	}
+	return nil
+}
+
+// sdkMaxAttempts is the number of attempts of the default retryer of the SDK, bypassed by a client calling the backend directly
+const sdkMaxAttempts = 3
+
+// callRetried records an API call like call, retrying the throttled calls as the default retryer of the SDK would,
+// unless optFns change RetryMaxAttempts. It must be called with the lock held.
+func (b *Backend) callRetried(op string, optFns []func(*cloudwatchlogs.Options)) error {
+	o := cloudwatchlogs.Options{RetryMaxAttempts: sdkMaxAttempts}
+	for _, fn := range optFns {
+		fn(&o)
+	}
+	for attempt := 1; ; attempt++ {
+		if err := b.call(op); err == nil || attempt >= o.RetryMaxAttempts {
+			return err
+		}
+	}
+}
+
+func notFound(groupName string) error {
+	return &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("The specified log group does not exist: %s", groupName))}
+}
+
+func parseToken(token *string) (int, error) {
+	if token == nil {
+		return 0, nil
+	}
+	offset, err := strconv.Atoi(*token)
+	if err != nil {
+		return 0, &types.InvalidParameterException{Message: aws.String("invalid next token")}
+	}
+	return offset, nil
+}
+
+// page returns the bounds of the page starting at offset and the token for the following one
+func (b *Backend) page(offset int, total int, limit *int32) (int, int, *string) {
+	size := b.pageSize()
+	if limit != nil && *limit > 0 && int(*limit) < size {
+		size = int(*limit)
+	}
+	if offset > total {
+		offset = total
+	}
+	end := offset + size
+	if end >= total {
+		return offset, total, nil
+	}
+	return offset, end, aws.String(strconv.Itoa(end))
+}
+
+// matchesPattern supports the unstructured subset of the filter pattern syntax:
+// every term, or double quoted phrase, must be present in the message.
+func matchesPattern(pattern string, message string) bool {
+	pattern = strings.TrimSpace(pattern)
+	for pattern != "" {
+		var term string
+		if strings.HasPrefix(pattern, `"`) {
+			end := strings.Index(pattern[1:], `"`)
+			if end < 0 {
+				term, pattern = pattern[1:], ""
+			} else {
+				term, pattern = pattern[1:end+1], pattern[end+2:]
+			}
+		} else {
+			fields := strings.SplitN(pattern, " ", 2)
+			term, pattern = fields[0], ""
+			if len(fields) > 1 {
+				pattern = fields[1]
+			}
+		}
+		if !strings.Contains(message, term) {
+			return false
+		}
+		pattern = strings.TrimSpace(pattern)
+	}
+	return true
+}
+
+// FilterLogEvents implements cloudwatchlogs.FilterLogEventsAPIClient
+func (b *Backend) FilterLogEvents(ctx context.Context, params *cloudwatchlogs.FilterLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.callRetried("FilterLogEvents", optFns); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if len(params.LogStreamNames) > 100 {
+		return nil, &types.InvalidParameterException{Message: aws.String("logStreamNames has more than 100 items")}
+	}
+	if len(params.LogStreamNames) > 0 && params.LogStreamNamePrefix != nil {
+		return nil, &types.InvalidParameterException{Message: aws.String("logStreamNames and logStreamNamePrefix are mutually exclusive")}
+	}
+	names := make(map[string]bool)
+	for _, n := range params.LogStreamNames {
+		names[n] = true
+	}
+
+	var events []types.FilteredLogEvent
+	for _, s := range g.streams {
+		if len(names) > 0 && !names[s.name] {
+			continue
+		}
+		if params.LogStreamNamePrefix != nil && !strings.HasPrefix(s.name, *params.LogStreamNamePrefix) {
+			continue
+		}
+		for _, e := range s.events {
+			if params.StartTime != nil && *e.Timestamp < *params.StartTime {
+				continue
+			}
+			if params.EndTime != nil && *e.Timestamp > *params.EndTime {
+				continue
+			}
+			if params.FilterPattern != nil && !matchesPattern(*params.FilterPattern, *e.Message) {
+				continue
+			}
+			events = append(events, e)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		if *events[i].Timestamp != *events[j].Timestamp {
+			return *events[i].Timestamp < *events[j].Timestamp
+		}
+		return *events[i].EventId < *events[j].EventId
+	})
+
+	offset, err := parseToken(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	from, to, next := b.page(offset, len(events), params.Limit)
+	return &cloudwatchlogs.FilterLogEventsOutput{Events: events[from:to], NextToken: next}, nil
+}
+
+func (s *stream) describe(groupName string) types.LogStream {
+	ls := types.LogStream{
+		LogStreamName: aws.String(s.name),
+		CreationTime:  aws.Int64(s.created),
+		Arn:           aws.String(fmt.Sprintf("arn:aws:logs:local:000000000000:log-group:%s:log-stream:%s", groupName, s.name)),
+	}
+	var size int64
+	for _, e := range s.events {
+		size += int64(len(*e.Message))
+		if ls.FirstEventTimestamp == nil || *e.Timestamp < *ls.FirstEventTimestamp {
+			ls.FirstEventTimestamp = aws.Int64(*e.Timestamp)
+		}
+		if ls.LastEventTimestamp == nil || *e.Timestamp > *ls.LastEventTimestamp {
+			ls.LastEventTimestamp = aws.Int64(*e.Timestamp)
+		}
+		if ls.LastIngestionTime == nil || *e.IngestionTime > *ls.LastIngestionTime {
+			ls.LastIngestionTime = aws.Int64(*e.IngestionTime)
+		}
+	}
+	ls.StoredBytes = aws.Int64(size)
+	return ls
+}
+
+// DescribeLogStreams implements cloudwatchlogs.DescribeLogStreamsAPIClient
+func (b *Backend) DescribeLogStreams(ctx context.Context, params *cloudwatchlogs.DescribeLogStreamsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DescribeLogStreams"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if params.OrderBy == types.OrderByLastEventTime && params.LogStreamNamePrefix != nil {
+		return nil, &types.InvalidParameterException{Message: aws.String("Cannot order by LastEventTime with a logStreamNamePrefix.")}
+	}
+
+	var streams []types.LogStream
+	for _, s := range g.streams {
+		if params.LogStreamNamePrefix != nil && !strings.HasPrefix(s.name, *params.LogStreamNamePrefix) {
+			continue
+		}
+		streams = append(streams, s.describe(g.name))
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		if params.OrderBy == types.OrderByLastEventTime {
+			return aws.ToInt64(streams[i].LastEventTimestamp) < aws.ToInt64(streams[j].LastEventTimestamp)
+		}
+		return *streams[i].LogStreamName < *streams[j].LogStreamName
+	})
+	if aws.ToBool(params.Descending) {
+		for i, j := 0, len(streams)-1; i < j; i, j = i+1, j-1 {
+			streams[i], streams[j] = streams[j], streams[i]
+		}
+	}
+
+	offset, err := parseToken(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	from, to, next := b.page(offset, len(streams), params.Limit)
+	return &cloudwatchlogs.DescribeLogStreamsOutput{LogStreams: streams[from:to], NextToken: next}, nil
+}
+
+// DescribeLogGroups implements cloudwatchlogs.DescribeLogGroupsAPIClient
+func (b *Backend) DescribeLogGroups(ctx context.Context, params *cloudwatchlogs.DescribeLogGroupsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogGroupsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DescribeLogGroups"); err != nil {
+		return nil, err
+	}
+	if params.LogGroupNamePrefix != nil && params.LogGroupNamePattern != nil {
+		return nil, &types.InvalidParameterException{Message: aws.String("logGroupNamePrefix and logGroupNamePattern are mutually exclusive")}
+	}
+
+	var groups []types.LogGroup
+	for _, g := range b.groups {
+		if params.LogGroupNamePrefix != nil && !strings.HasPrefix(g.name, *params.LogGroupNamePrefix) {
+			continue
+		}
+		if params.LogGroupNamePattern != nil && !strings.Contains(strings.ToLower(g.name), strings.ToLower(*params.LogGroupNamePattern)) {
+			continue
+		}
+		var size int64
+		for _, s := range g.streams {
+			size += *s.describe(g.name).StoredBytes
+		}
+		lg := types.LogGroup{
+			LogGroupName: aws.String(g.name),
+			CreationTime: aws.Int64(g.created),
+			StoredBytes:  aws.Int64(size),
+			Arn:          aws.String(groupArn(g.name) + ":*"),
+		}
+		if len(g.filters) > 0 {
+			lg.MetricFilterCount = aws.Int32(int32(len(g.filters)))
+		}
+		if g.retention > 0 {
+			lg.RetentionInDays = aws.Int32(g.retention)
+		}
+		groups = append(groups, lg)
+	}
+	sort.Slice(groups, func(i, j int) bool { return *groups[i].LogGroupName < *groups[j].LogGroupName })
+
+	offset, err := parseToken(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	from, to, next := b.page(offset, len(groups), params.Limit)
+	return &cloudwatchlogs.DescribeLogGroupsOutput{LogGroups: groups[from:to], NextToken: next}, nil
+}
+
+// CreateLogGroup creates a log group, failing if it already exists
+func (b *Backend) CreateLogGroup(ctx context.Context, params *cloudwatchlogs.CreateLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogGroupOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("CreateLogGroup"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.LogGroupName)
+	if _, ok := b.groups[name]; ok {
+		return nil, &types.ResourceAlreadyExistsException{Message: aws.String("The specified log group already exists")}
+	}
+	g := b.group(name)
+	for k, v := range params.Tags {
+		if g.tags == nil {
+			g.tags = make(map[string]string)
+		}
+		g.tags[k] = v
+	}
+	return &cloudwatchlogs.CreateLogGroupOutput{}, nil
+}
+
+func groupArn(name string) string {
+	return fmt.Sprintf("arn:aws:logs:local:000000000000:log-group:%s", name)
+}
+
+// groupByArn returns the log group with the given ARN, with or without the :* suffix
+func (b *Backend) groupByArn(arn string) (*group, error) {
+	for _, g := range b.groups {
+		if arn == groupArn(g.name) || arn == groupArn(g.name)+":*" {
+			return g, nil
+		}
+	}
+	return nil, &types.ResourceNotFoundException{Message: aws.String("The specified resource does not exist: " + arn)}
+}
+
+// DeleteLogGroup deletes a log group and all its streams
+func (b *Backend) DeleteLogGroup(ctx context.Context, params *cloudwatchlogs.DeleteLogGroupInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteLogGroupOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DeleteLogGroup"); err != nil {
+		return nil, err
+	}
+	name := aws.ToString(params.LogGroupName)
+	if _, ok := b.groups[name]; !ok {
+		return nil, notFound(name)
+	}
+	delete(b.groups, name)
+	return &cloudwatchlogs.DeleteLogGroupOutput{}, nil
+}
+
+// PutRetentionPolicy sets the retention of a log group, accepting only the values allowed by the service
+func (b *Backend) PutRetentionPolicy(ctx context.Context, params *cloudwatchlogs.PutRetentionPolicyInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutRetentionPolicyOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("PutRetentionPolicy"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	days := aws.ToInt32(params.RetentionInDays)
+	valid := false
+	for _, d := range []int32{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653} {
+		valid = valid || d == days
+	}
+	if !valid {
+		return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("Invalid retentionInDays: %d", days))}
+	}
+	g.retention = days
+	return &cloudwatchlogs.PutRetentionPolicyOutput{}, nil
+}
+
+// DeleteRetentionPolicy makes the events of a log group never expire
+func (b *Backend) DeleteRetentionPolicy(ctx context.Context, params *cloudwatchlogs.DeleteRetentionPolicyInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteRetentionPolicyOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DeleteRetentionPolicy"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	g.retention = 0
+	return &cloudwatchlogs.DeleteRetentionPolicyOutput{}, nil
+}
+
+// TagResource adds tags to the log group with the given ARN
+func (b *Backend) TagResource(ctx context.Context, params *cloudwatchlogs.TagResourceInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.TagResourceOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("TagResource"); err != nil {
+		return nil, err
+	}
+	g, err := b.groupByArn(aws.ToString(params.ResourceArn))
+	if err != nil {
+		return nil, err
+	}
+	if g.tags == nil {
+		g.tags = make(map[string]string)
+	}
+	for k, v := range params.Tags {
+		g.tags[k] = v
+	}
+	return &cloudwatchlogs.TagResourceOutput{}, nil
+}
+
+// UntagResource removes tags from the log group with the given ARN
+func (b *Backend) UntagResource(ctx context.Context, params *cloudwatchlogs.UntagResourceInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.UntagResourceOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("UntagResource"); err != nil {
+		return nil, err
+	}
+	g, err := b.groupByArn(aws.ToString(params.ResourceArn))
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range params.TagKeys {
+		delete(g.tags, k)
+	}
+	return &cloudwatchlogs.UntagResourceOutput{}, nil
+}
+
+// Tags returns a copy of the tags of a log group
+func (b *Backend) Tags(groupName string) map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	tags := make(map[string]string)
+	if g, ok := b.groups[groupName]; ok {
+		for k, v := range g.tags {
+			tags[k] = v
+		}
+	}
+	return tags
+}
+
+// CreateLogStream creates a log stream in an existing log group, failing if it already exists
+func (b *Backend) CreateLogStream(ctx context.Context, params *cloudwatchlogs.CreateLogStreamInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.CreateLogStreamOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("CreateLogStream"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if _, ok := g.streams[aws.ToString(params.LogStreamName)]; ok {
+		return nil, &types.ResourceAlreadyExistsException{Message: aws.String("The specified log stream already exists")}
+	}
+	b.stream(g.name, aws.ToString(params.LogStreamName))
+	return &cloudwatchlogs.CreateLogStreamOutput{}, nil
+}
+
+// PutLogEvents stores the events, enforcing the limits of the service: at most 10,000 events and 1,048,576 bytes,
+// counting 26 bytes per event, in chronological order and spanning at most 24 hours.
+// Events older than 14 days or more than 2 hours in the future are rejected.
+func (b *Backend) PutLogEvents(ctx context.Context, params *cloudwatchlogs.PutLogEventsInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutLogEventsOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("PutLogEvents"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if _, ok := g.streams[aws.ToString(params.LogStreamName)]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String(fmt.Sprintf("The specified log stream does not exist: %s", aws.ToString(params.LogStreamName)))}
+	}
+	invalid := func(msg string) error {
+		return &types.InvalidParameterException{Message: aws.String(msg)}
+	}
+	if len(params.LogEvents) == 0 || len(params.LogEvents) > 10000 {
+		return nil, invalid("logEvents must contain between 1 and 10000 events")
+	}
+	size := 0
+	for i, e := range params.LogEvents {
+		size += len(aws.ToString(e.Message)) + 26
+		if i > 0 && aws.ToInt64(e.Timestamp) < aws.ToInt64(params.LogEvents[i-1].Timestamp) {
+			return nil, invalid("Log events in a single PutLogEvents request must be in chronological order.")
+		}
+	}
+	if size > 1048576 {
+		return nil, invalid("Upload too large: the batch exceeds 1048576 bytes")
+	}
+	first, last := aws.ToInt64(params.LogEvents[0].Timestamp), aws.ToInt64(params.LogEvents[len(params.LogEvents)-1].Timestamp)
+	if last-first > int64(24*time.Hour/time.Millisecond) {
+		return nil, invalid("The batch of log events in a single PutLogEvents request cannot span more than 24 hours.")
+	}
+
+	now := b.now()
+	tooOld, tooNew := -1, len(params.LogEvents)
+	var events []Event
+	for i, e := range params.LogEvents {
+		ts := aws.ToInt64(e.Timestamp)
+		switch {
+		case ts < now-int64(14*24*time.Hour/time.Millisecond):
+			tooOld = i
+		case ts > now+int64(2*time.Hour/time.Millisecond):
+			if i < tooNew {
+				tooNew = i
+			}
+		default:
+			events = append(events, Event{Timestamp: ts, Message: aws.ToString(e.Message)})
+		}
+	}
+	b.addEvents(g.name, aws.ToString(params.LogStreamName), events)
+
+	out := &cloudwatchlogs.PutLogEventsOutput{}
+	if tooOld >= 0 || tooNew < len(params.LogEvents) {
+		out.RejectedLogEventsInfo = &types.RejectedLogEventsInfo{}
+		if tooOld >= 0 {
+			out.RejectedLogEventsInfo.TooOldLogEventEndIndex = aws.Int32(int32(tooOld))
+		}
+		if tooNew < len(params.LogEvents) {
+			out.RejectedLogEventsInfo.TooNewLogEventStartIndex = aws.Int32(int32(tooNew))
+		}
+	}
+	return out, nil
+}
+
+var (
+	_ cloudwatchlogs.FilterLogEventsAPIClient    = (*Backend)(nil)
+	_ cloudwatchlogs.DescribeLogStreamsAPIClient = (*Backend)(nil)
+	_ cloudwatchlogs.DescribeLogGroupsAPIClient  = (*Backend)(nil)
+)
@@ -0,0 +1,41 @@
+package fake
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDescribeLogStreamsPagination(t *testing.T) {
+	a := assert.New(t)
+	b := New()
+	b.PageSize = 2
+	for _, s := range []string{"c", "a", "b", "d", "e"} {
+		b.AddStream("group", s)
+	}
+
+	var names []string
+	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(b, &cloudwatchlogs.DescribeLogStreamsInput{LogGroupName: aws.String("group")})
+	for paginator.HasMorePages() {
+		res, err := paginator.NextPage(context.Background())
+		a.NoError(err)
+		a.LessOrEqual(len(res.LogStreams), 2)
+		for _, s := range res.LogStreams {
+			names = append(names, *s.LogStreamName)
+		}
+	}
+	a.Equal([]string{"a", "b", "c", "d", "e"}, names)
+	a.Equal(3, b.Calls("DescribeLogStreams"))
+}
+
+func TestFilterPattern(t *testing.T) {
+	a := assert.New(t)
+	a.True(matchesPattern("", "anything"))
+	a.True(matchesPattern("ERROR timeout", "ERROR: upstream timeout"))
+	a.False(matchesPattern("ERROR timeout", "ERROR: upstream refused"))
+	a.True(matchesPattern(`"connection reset" ERROR`, "ERROR connection reset by peer"))
+	a.False(matchesPattern(`"connection reset"`, "connection was reset"))
+}
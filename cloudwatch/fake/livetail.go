@@ -0,0 +1,255 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// liveTailInput is the JSON body of a StartLiveTail request
+type liveTailInput struct {
+	LogGroupIdentifiers   []string `json:"logGroupIdentifiers"`
+	LogStreamNames        []string `json:"logStreamNames"`
+	LogStreamNamePrefixes []string `json:"logStreamNamePrefixes"`
+	LogEventFilterPattern *string  `json:"logEventFilterPattern"`
+}
+
+type liveTailEvent struct {
+	IngestionTime      int64  `json:"ingestionTime"`
+	LogGroupIdentifier string `json:"logGroupIdentifier"`
+	LogStreamName      string `json:"logStreamName"`
+	Message            string `json:"message"`
+	Timestamp          int64  `json:"timestamp"`
+}
+
+// liveSession is a Live Tail session receiving the events added to the backend
+type liveSession struct {
+	input  liveTailInput
+	groups map[string]string
+	events chan []liveTailEvent
+}
+
+// publish sends the events matching the session. It must be called with the backend lock held.
+func (s *liveSession) publish(groupName string, events []types.FilteredLogEvent) {
+	arn, ok := s.groups[groupName]
+	if !ok {
+		return
+	}
+	var results []liveTailEvent
+	for _, e := range events {
+		if !s.matchesStream(*e.LogStreamName) {
+			continue
+		}
+		if s.input.LogEventFilterPattern != nil && !matchesPattern(*s.input.LogEventFilterPattern, *e.Message) {
+			continue
+		}
+		results = append(results, liveTailEvent{
+			IngestionTime:      *e.IngestionTime,
+			LogGroupIdentifier: arn,
+			LogStreamName:      *e.LogStreamName,
+			Message:            *e.Message,
+			Timestamp:          *e.Timestamp,
+		})
+	}
+	if len(results) == 0 {
+		return
+	}
+	select {
+	case s.events <- results:
+	default:
+		// the session is not keeping up: like Live Tail the results are dropped
+	}
+}
+
+func (s *liveSession) matchesStream(name string) bool {
+	if len(s.input.LogStreamNames) == 0 && len(s.input.LogStreamNamePrefixes) == 0 {
+		return true
+	}
+	for _, n := range s.input.LogStreamNames {
+		if n == name {
+			return true
+		}
+	}
+	for _, p := range s.input.LogStreamNamePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// LiveTailServer serves the StartLiveTail event stream of a Backend over HTTP.
+// Sessions stream the events added to the backend once they have started.
+type LiveTailServer struct {
+	*httptest.Server
+	backend        *Backend
+	sessionTimeout time.Duration
+}
+
+// NewLiveTailServer starts a LiveTailServer. When sessionTimeout is not zero, every session
+// ends with a SessionTimeoutException once it has been open for sessionTimeout.
+func NewLiveTailServer(b *Backend, sessionTimeout time.Duration) *LiveTailServer {
+	s := &LiveTailServer{backend: b, sessionTimeout: sessionTimeout}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// LogsClient returns a CloudWatch Logs client sending its requests to the server
+func (s *LiveTailServer) LogsClient() *cloudwatchlogs.Client {
+	return cloudwatchlogs.New(cloudwatchlogs.Options{
+		Region:       "local",
+		BaseEndpoint: aws.String(s.URL),
+		Credentials: aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: "fake", SecretAccessKey: "fake"}, nil
+		}),
+		RetryMaxAttempts: 1,
+		// StartLiveTail is sent to the streaming- host, which the server is not
+		APIOptions: []func(*middleware.Stack) error{func(stack *middleware.Stack) error {
+			return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("DisableEndpointHostPrefix",
+				func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+					return next.HandleInitialize(smithyhttp.DisableEndpointHostPrefix(ctx, true), in)
+				}), middleware.Before)
+		}},
+	})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code, message := "InternalFailure", err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code, message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	w.Header().Set("X-Amzn-Errortype", code)
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": message})
+}
+
+func groupName(identifier string) string {
+	if i := strings.Index(identifier, ":log-group:"); i >= 0 {
+		return strings.TrimSuffix(identifier[i+len(":log-group:"):], ":*")
+	}
+	return identifier
+}
+
+func (s *LiveTailServer) start(r *http.Request) (*liveSession, error) {
+	if !strings.HasSuffix(r.Header.Get("X-Amz-Target"), ".StartLiveTail") {
+		return nil, &smithy.GenericAPIError{Code: "UnknownOperationException", Message: "only StartLiveTail is supported"}
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	session := &liveSession{groups: make(map[string]string), events: make(chan []liveTailEvent, 1000)}
+	if err := json.Unmarshal(body, &session.input); err != nil {
+		return nil, &types.InvalidParameterException{Message: aws.String(err.Error())}
+	}
+
+	b := s.backend
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("StartLiveTail"); err != nil {
+		return nil, err
+	}
+	for _, id := range session.input.LogGroupIdentifiers {
+		name := groupName(id)
+		if _, ok := b.groups[name]; !ok {
+			return nil, notFound(name)
+		}
+		session.groups[name] = id
+	}
+	b.sessions[session] = true
+	return session, nil
+}
+
+func (s *LiveTailServer) stop(session *liveSession) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+	delete(s.backend.sessions, session)
+}
+
+func message(messageType, eventHeader, eventType string, payload interface{}) (eventstream.Message, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return eventstream.Message{}, err
+	}
+	msg := eventstream.Message{Payload: b}
+	msg.Headers.Set(":message-type", eventstream.StringValue(messageType))
+	msg.Headers.Set(eventHeader, eventstream.StringValue(eventType))
+	msg.Headers.Set(":content-type", eventstream.StringValue("application/json"))
+	return msg, nil
+}
+
+func (s *LiveTailServer) serve(w http.ResponseWriter, r *http.Request) {
+	session, err := s.start(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer s.stop(session)
+
+	w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := eventstream.NewEncoder()
+	send := func(messageType, eventHeader, eventType string, payload interface{}) bool {
+		msg, err := message(messageType, eventHeader, eventType, payload)
+		if err != nil || encoder.Encode(w, msg) != nil {
+			return false
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return true
+	}
+
+	sessionID := fmt.Sprintf("session-%p", session)
+	if !send("event", ":event-type", "initial-response", struct{}{}) ||
+		!send("event", ":event-type", "sessionStart", map[string]interface{}{
+			"sessionId":             sessionID,
+			"requestId":             sessionID,
+			"logGroupIdentifiers":   session.input.LogGroupIdentifiers,
+			"logStreamNames":        session.input.LogStreamNames,
+			"logStreamNamePrefixes": session.input.LogStreamNamePrefixes,
+		}) {
+		return
+	}
+
+	var timeout <-chan time.Time
+	if s.sessionTimeout > 0 {
+		timer := time.NewTimer(s.sessionTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		select {
+		case results := <-session.events:
+			update := map[string]interface{}{
+				"sessionMetadata": map[string]bool{"sampled": false},
+				"sessionResults":  results,
+			}
+			if !send("event", ":event-type", "sessionUpdate", update) {
+				return
+			}
+		case <-timeout:
+			send("exception", ":exception-type", "SessionTimeoutException", map[string]string{"message": "Session timed out"})
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package fake
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// DescribeMetricFilters implements cloudwatchlogs.DescribeMetricFiltersAPIClient
+func (b *Backend) DescribeMetricFilters(ctx context.Context, params *cloudwatchlogs.DescribeMetricFiltersInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeMetricFiltersOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DescribeMetricFilters"); err != nil {
+		return nil, err
+	}
+	groupName := aws.ToString(params.LogGroupName)
+	if groupName != "" {
+		if _, ok := b.groups[groupName]; !ok {
+			return nil, notFound(groupName)
+		}
+	}
+
+	var filters []types.MetricFilter
+	for _, g := range b.groups {
+		if groupName != "" && g.name != groupName {
+			continue
+		}
+		for _, f := range g.filters {
+			// like the service, the prefix is only used together with a log group
+			if groupName != "" && !strings.HasPrefix(aws.ToString(f.FilterName), aws.ToString(params.FilterNamePrefix)) {
+				continue
+			}
+			filters = append(filters, f)
+		}
+	}
+	sort.Slice(filters, func(i, j int) bool {
+		if *filters[i].LogGroupName != *filters[j].LogGroupName {
+			return *filters[i].LogGroupName < *filters[j].LogGroupName
+		}
+		return *filters[i].FilterName < *filters[j].FilterName
+	})
+
+	offset, err := parseToken(params.NextToken)
+	if err != nil {
+		return nil, err
+	}
+	from, to, next := b.page(offset, len(filters), params.Limit)
+	return &cloudwatchlogs.DescribeMetricFiltersOutput{MetricFilters: filters[from:to], NextToken: next}, nil
+}
+
+// PutMetricFilter creates or replaces a metric filter, rejecting the patterns with a syntax error
+func (b *Backend) PutMetricFilter(ctx context.Context, params *cloudwatchlogs.PutMetricFilterInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.PutMetricFilterOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("PutMetricFilter"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if err := checkPattern(aws.ToString(params.FilterPattern)); err != nil {
+		return nil, err
+	}
+	if len(params.MetricTransformations) != 1 {
+		return nil, &types.InvalidParameterException{Message: aws.String("Exactly one metric transformation is required")}
+	}
+	if g.filters == nil {
+		g.filters = make(map[string]types.MetricFilter)
+	}
+	g.filters[aws.ToString(params.FilterName)] = types.MetricFilter{
+		FilterName:            params.FilterName,
+		FilterPattern:         params.FilterPattern,
+		LogGroupName:          params.LogGroupName,
+		MetricTransformations: params.MetricTransformations,
+		CreationTime:          aws.Int64(b.now()),
+	}
+	return &cloudwatchlogs.PutMetricFilterOutput{}, nil
+}
+
+// DeleteMetricFilter deletes a metric filter
+func (b *Backend) DeleteMetricFilter(ctx context.Context, params *cloudwatchlogs.DeleteMetricFilterInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DeleteMetricFilterOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("DeleteMetricFilter"); err != nil {
+		return nil, err
+	}
+	g, ok := b.groups[aws.ToString(params.LogGroupName)]
+	if !ok {
+		return nil, notFound(aws.ToString(params.LogGroupName))
+	}
+	if _, ok := g.filters[aws.ToString(params.FilterName)]; !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("The specified metric filter does not exist.")}
+	}
+	delete(g.filters, aws.ToString(params.FilterName))
+	return &cloudwatchlogs.DeleteMetricFilterOutput{}, nil
+}
+
+// TestMetricFilter matches the messages against the pattern. It supports the unstructured patterns, see matchesPattern,
+// and the space delimited ones, e.g. [ip, user, status, ...], whose fields are extracted but not compared.
+func (b *Backend) TestMetricFilter(ctx context.Context, params *cloudwatchlogs.TestMetricFilterInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.TestMetricFilterOutput, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.call("TestMetricFilter"); err != nil {
+		return nil, err
+	}
+	pattern := strings.TrimSpace(aws.ToString(params.FilterPattern))
+	if err := checkPattern(pattern); err != nil {
+		return nil, err
+	}
+	if n := len(params.LogEventMessages); n == 0 || n > 50 {
+		return nil, &types.InvalidParameterException{Message: aws.String("logEventMessages must contain between 1 and 50 messages")}
+	}
+	if strings.HasPrefix(pattern, "{") {
+		return nil, &types.InvalidParameterException{Message: aws.String("JSON patterns are not supported by the fake backend")}
+	}
+
+	var fields []string
+	if strings.HasPrefix(pattern, "[") {
+		for _, f := range strings.Split(strings.Trim(pattern, "[]"), ",") {
+			fields = append(fields, strings.TrimSpace(f))
+		}
+	}
+	out := &cloudwatchlogs.TestMetricFilterOutput{}
+	for i, msg := range params.LogEventMessages {
+		record := types.MetricFilterMatchRecord{EventNumber: int64(i + 1), EventMessage: aws.String(msg), ExtractedValues: map[string]string{}}
+		if fields == nil {
+			if !matchesPattern(pattern, msg) {
+				continue
+			}
+		} else {
+			values := strings.Fields(msg)
+			if len(values) < len(fields) || (fields[len(fields)-1] != "..." && len(values) > len(fields)) {
+				continue
+			}
+			for j, f := range fields {
+				if f != "..." {
+					record.ExtractedValues["$"+f] = values[j]
+				}
+			}
+		}
+		out.Matches = append(out.Matches, record)
+	}
+	return out, nil
+}
+
+// checkPattern rejects the patterns with unbalanced quotes, brackets or braces
+func checkPattern(pattern string) error {
+	if strings.Count(pattern, `"`)%2 != 0 ||
+		strings.Count(pattern, "[") != strings.Count(pattern, "]") ||
+		strings.Count(pattern, "{") != strings.Count(pattern, "}") {
+		return &types.InvalidParameterException{Message: aws.String("Invalid metric filter pattern")}
+	}
+	return nil
+}
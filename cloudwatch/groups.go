@@ -0,0 +1,114 @@
+package cloudwatch
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// RetentionDays are the retention periods, in days, accepted by PutRetentionPolicy
+var RetentionDays = []int32{1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+//DescribeGroup returns the log group with the given name
+//It returns a ResourceNotFoundException if the log group does not exist
+func DescribeGroup(ctx context.Context, cwc cloudwatchlogs.DescribeLogGroupsAPIClient, logGroupName string) (types.LogGroup, error) {
+	groups, errCh := LsGroups(ctx, cwc, &logGroupName, nil)
+	var found *types.LogGroup
+	for g := range groups {
+		if aws.ToString(g.LogGroupName) == logGroupName {
+			g := g
+			found = &g
+		}
+	}
+	if err := <-errCh; err != nil {
+		return types.LogGroup{}, err
+	}
+	if found == nil {
+		return types.LogGroup{}, &types.ResourceNotFoundException{Message: aws.String("The specified log group does not exist: " + logGroupName)}
+	}
+	return *found, nil
+}
+
+// groupArn returns the ARN of the log group, without the :* suffix of the ARN of its streams
+func groupArn(ctx context.Context, cwc cloudwatchlogs.DescribeLogGroupsAPIClient, logGroupName string) (string, error) {
+	g, err := DescribeGroup(ctx, cwc, logGroupName)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(aws.ToString(g.Arn), ":*"), nil
+}
+
+// Groups manages log groups, retrying the transient errors according to RetryPolicy
+type Groups struct {
+	Client GroupsAPIClient
+	RetryPolicy *RetryPolicy
+	Logger      *log.Logger
+}
+
+//Create creates the log group with the given tags
+func (g *Groups) Create(ctx context.Context, name string, tags map[string]string) error {
+	return withRetry(ctx, g.RetryPolicy, g.Logger, name, func() error {
+		params := &cloudwatchlogs.CreateLogGroupInput{LogGroupName: &name}
+		if len(tags) > 0 {
+			params.Tags = tags
+		}
+		_, err := g.Client.CreateLogGroup(ctx, params)
+		return err
+	})
+}
+
+//Delete deletes the log group and all its events
+func (g *Groups) Delete(ctx context.Context, name string) error {
+	return withRetry(ctx, g.RetryPolicy, g.Logger, name, func() error {
+		_, err := g.Client.DeleteLogGroup(ctx, &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: &name})
+		return err
+	})
+}
+
+//SetRetention sets the number of days the events of the log group are kept. With 0 days they never expire
+func (g *Groups) SetRetention(ctx context.Context, name string, days int32) error {
+	return withRetry(ctx, g.RetryPolicy, g.Logger, name, func() error {
+		if days == 0 {
+			_, err := g.Client.DeleteRetentionPolicy(ctx, &cloudwatchlogs.DeleteRetentionPolicyInput{LogGroupName: &name})
+			return err
+		}
+		_, err := g.Client.PutRetentionPolicy(ctx, &cloudwatchlogs.PutRetentionPolicyInput{LogGroupName: &name, RetentionInDays: &days})
+		return err
+	})
+}
+
+//Tag adds the tags to the log group, replacing the values of the existing keys
+func (g *Groups) Tag(ctx context.Context, name string, tags map[string]string) error {
+	var arn string
+	err := withRetry(ctx, g.RetryPolicy, g.Logger, name, func() (err error) {
+		arn, err = groupArn(ctx, g.Client, name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return withRetry(ctx, g.RetryPolicy, g.Logger, name, func() error {
+		_, err := g.Client.TagResource(ctx, &cloudwatchlogs.TagResourceInput{ResourceArn: &arn, Tags: tags})
+		return err
+	})
+}
+
+//Untag removes the tags with the given keys from the log group
+func (g *Groups) Untag(ctx context.Context, name string, keys []string) error {
+	var arn string
+	err := withRetry(ctx, g.RetryPolicy, g.Logger, name, func() (err error) {
+		arn, err = groupArn(ctx, g.Client, name)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return withRetry(ctx, g.RetryPolicy, g.Logger, name, func() error {
+		_, err := g.Client.UntagResource(ctx, &cloudwatchlogs.UntagResourceInput{ResourceArn: &arn, TagKeys: keys})
+		return err
+	})
+}
@@ -0,0 +1,47 @@
+package cloudwatch
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupsLifecycle(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	backend := fake.New()
+	backend.Throttle(2)
+	g := &Groups{
+		Client:      backend,
+		RetryPolicy: fastRetryPolicy,
+		Logger:      log.New(io.Discard, "", 0),
+	}
+
+	a.NoError(g.Create(ctx, "/app/orders", map[string]string{"team": "payments"}))
+	a.True(isAlreadyExists(g.Create(ctx, "/app/orders", nil)))
+
+	a.NoError(g.SetRetention(ctx, "/app/orders", 30))
+	group, err := DescribeGroup(ctx, backend, "/app/orders")
+	a.NoError(err)
+	a.Equal(int32(30), aws.ToInt32(group.RetentionInDays))
+	a.NoError(g.SetRetention(ctx, "/app/orders", 0))
+	group, _ = DescribeGroup(ctx, backend, "/app/orders")
+	a.Nil(group.RetentionInDays)
+
+	a.NoError(g.Tag(ctx, "/app/orders", map[string]string{"env": "prod", "team": "billing"}))
+	a.Equal(map[string]string{"env": "prod", "team": "billing"}, backend.Tags("/app/orders"))
+	a.NoError(g.Untag(ctx, "/app/orders", []string{"team"}))
+	a.Equal(map[string]string{"env": "prod"}, backend.Tags("/app/orders"))
+
+	a.NoError(g.Delete(ctx, "/app/orders"))
+	_, err = DescribeGroup(ctx, backend, "/app/orders")
+	var notFound *types.ResourceNotFoundException
+	a.ErrorAs(err, &notFound)
+	a.ErrorAs(g.Tag(ctx, "/app/orders", map[string]string{"env": "prod"}), &notFound)
+}
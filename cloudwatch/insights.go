@@ -0,0 +1,69 @@
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+const insightsPollFreq = 1 * time.Second
+
+type QueryConfig struct {
+	LogGroupNames []string
+	Query         *string
+	StartTime     *time.Time
+	EndTime       *time.Time
+	Limit         *int32
+}
+
+// Insights runs a CloudWatch Logs Insights query against the given log groups
+// It polls the query status until the query is complete and returns the result rows
+// The query is stopped if ctx is cancelled before it completes
+func Insights(ctx context.Context, cwc InsightsAPIClient, queryConfig QueryConfig, logger *log.Logger) ([][]types.ResultField, error) {
+	params := &cloudwatchlogs.StartQueryInput{
+		LogGroupNames: queryConfig.LogGroupNames,
+		QueryString:   queryConfig.Query,
+		StartTime:     aws.Int64(queryConfig.StartTime.Unix()),
+		EndTime:       aws.Int64(queryConfig.EndTime.Unix()),
+	}
+	if queryConfig.Limit != nil && *queryConfig.Limit > 0 {
+		params.Limit = queryConfig.Limit
+	}
+
+	res, err := cwc.StartQuery(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("insights: query %s started\n", *res.QueryId)
+
+	ticker := time.NewTicker(insightsPollFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			// the query keeps running server side unless explicitly stopped
+			_, _ = cwc.StopQuery(context.Background(), &cloudwatchlogs.StopQueryInput{QueryId: res.QueryId})
+			return nil, ctx.Err()
+		}
+		out, err := cwc.GetQueryResults(ctx, &cloudwatchlogs.GetQueryResultsInput{QueryId: res.QueryId})
+		if err != nil {
+			return nil, err
+		}
+		logger.Printf("insights: query %s status %s\n", *res.QueryId, out.Status)
+
+		switch out.Status {
+		case types.QueryStatusComplete:
+			return out.Results, nil
+		case types.QueryStatusScheduled, types.QueryStatusRunning:
+			continue
+		default:
+			return nil, fmt.Errorf("insights query %s did not complete: %s", *res.QueryId, out.Status)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// ErrLiveTailUnavailable is returned by LiveTail when no Live Tail session could be started for the log group,
+// e.g. because the group does not exist or Live Tail is not allowed. Polling with Tail can be used instead.
+var ErrLiveTailUnavailable = errors.New("live tail unavailable")
+
+// liveTailSession reads the events of a Live Tail session until it ends
+type liveTailSession struct {
+	ctx        context.Context
+	tailConfig TailConfig
+	grepv      *regexp.Regexp
+	ch         chan<- types.FilteredLogEvent
+	logger     *log.Logger
+	sampled    bool
+}
+
+func (s *liveTailSession) read(stream *cloudwatchlogs.StartLiveTailEventStream) error {
+	defer stream.Close()
+	var sessionID string
+	seq := 0
+	for {
+		select {
+		case ev, ok := <-stream.Events():
+			if !ok {
+				return stream.Err()
+			}
+			switch e := ev.(type) {
+			case *types.StartLiveTailResponseStreamMemberSessionStart:
+				if e.Value.SessionId != nil {
+					sessionID = *e.Value.SessionId
+				}
+				s.logger.Printf("%s: live tail session %s started\n", *s.tailConfig.LogGroupName, sessionID)
+			case *types.StartLiveTailResponseStreamMemberSessionUpdate:
+				if e.Value.SessionMetadata != nil && e.Value.SessionMetadata.Sampled && !s.sampled {
+					s.sampled = true
+					if s.tailConfig.Warn != nil {
+						s.tailConfig.Warn(fmt.Sprintf("%s: live tail results are sampled, not all the events are shown", *s.tailConfig.LogGroupName))
+					}
+				}
+				for _, result := range e.Value.SessionResults {
+					seq++
+					if err := s.publish(result, fmt.Sprintf("live-%s-%d", sessionID, seq)); err != nil {
+						return err
+					}
+				}
+			default:
+				s.logger.Printf("%s: unknown live tail event %T\n", *s.tailConfig.LogGroupName, ev)
+			}
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		}
+	}
+}
+
+func (s *liveTailSession) publish(result types.LiveTailSessionLogEvent, id string) error {
+	if result.Message == nil || result.LogStreamName == nil {
+		return nil
+	}
+	if s.tailConfig.LogStreamPattern != nil && !s.tailConfig.LogStreamPattern.MatchString(*result.LogStreamName) {
+		return nil
+	}
+	if s.grepv != nil && s.grepv.MatchString(*result.Message) {
+		return nil
+	}
+	event := types.FilteredLogEvent{
+		EventId:       &id,
+		IngestionTime: result.IngestionTime,
+		LogStreamName: result.LogStreamName,
+		Message:       result.Message,
+		Timestamp:     result.Timestamp,
+	}
+	select {
+	case s.ch <- event:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+//LiveTail streams the new events of the log group with a Live Tail session rather than by polling.
+//Only the events ingested after the session has started are published: StartTime, EndTime, Follow and Resume are ignored.
+//Sessions are reconnected when they time out or end, and on transient errors according to the configured RetryPolicy.
+//Events published by Live Tail have no id: a unique id is generated for each of them.
+//If the first session can't be started the error published wraps ErrLiveTailUnavailable. An invalid Grepv is published as an error.
+//Both channels are closed when tailing stops or when ctx is cancelled
+func LiveTail(ctx context.Context,
+	cwc LiveTailAPIClient,
+	tailConfig TailConfig,
+	logger *log.Logger) (<-chan types.FilteredLogEvent, <-chan error) {
+
+	ch := make(chan types.FilteredLogEvent, 1000)
+	errCh := make(chan error, 1)
+
+	policy := policyOrDefault(tailConfig.RetryPolicy)
+	session := &liveTailSession{ctx: ctx, tailConfig: tailConfig, ch: ch, logger: logger}
+	if tailConfig.Grepv != nil && *tailConfig.Grepv != "" {
+		var err error
+		if session.grepv, err = regexp.Compile(*tailConfig.Grepv); err != nil {
+			errCh <- fmt.Errorf("invalid grepv pattern %q: %w", *tailConfig.Grepv, err)
+			close(errCh)
+			close(ch)
+			return ch, errCh
+		}
+	}
+
+	go func() {
+		defer close(errCh)
+		defer close(ch)
+
+		arn, err := groupArn(ctx, cwc, *tailConfig.LogGroupName)
+		if err != nil {
+			if ctx.Err() == nil {
+				errCh <- fmt.Errorf("%w: %v", ErrLiveTailUnavailable, err)
+			}
+			return
+		}
+		params := &cloudwatchlogs.StartLiveTailInput{LogGroupIdentifiers: []string{arn}}
+		if tailConfig.LogStreamName != nil && *tailConfig.LogStreamName != "" {
+			params.LogStreamNamePrefixes = []string{*tailConfig.LogStreamName}
+		}
+		if tailConfig.Grep != nil && *tailConfig.Grep != "" {
+			params.LogEventFilterPattern = tailConfig.Grep
+		}
+
+		started := false
+		for attempt := 0; ; {
+			out, err := cwc.StartLiveTail(ctx, params)
+			if err == nil {
+				started = true
+				attempt = 0
+				err = session.read(out.GetStream())
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			var timeout *types.SessionTimeoutException
+			var streaming *types.SessionStreamingException
+			delay := policy.BaseDelay
+			if err == nil || errors.As(err, &timeout) {
+				logger.Printf("%s: live tail session ended, reconnecting.\n", *tailConfig.LogGroupName)
+			} else {
+				retryable := IsRetryableError(err) || errors.As(err, &streaming)
+				if !retryable || attempt+1 >= policy.MaxAttempts {
+					if !started {
+						err = fmt.Errorf("%w: %v", ErrLiveTailUnavailable, err)
+					}
+					errCh <- err
+					return
+				}
+				delay = policy.backoff(attempt)
+				attempt++
+				logger.Printf("%s: live tail attempt %d failed with %s. Retry in %s.\n", *tailConfig.LogGroupName, attempt, err.Error(), delay)
+			}
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, errCh
+}
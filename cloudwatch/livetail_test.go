@@ -0,0 +1,110 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+// liveTailClient lists the groups from the backend and streams the events from the fake Live Tail server
+type liveTailClient struct {
+	*fake.Backend
+	live *cloudwatchlogs.Client
+}
+
+func (c liveTailClient) StartLiveTail(ctx context.Context, params *cloudwatchlogs.StartLiveTailInput, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.StartLiveTailOutput, error) {
+	return c.live.StartLiveTail(ctx, params, optFns...)
+}
+
+func waitForSessions(t *testing.T, backend *fake.Backend, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.Calls("StartLiveTail") < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("live tail session %d not started", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	// the session is registered with the backend right after the call
+	time.Sleep(20 * time.Millisecond)
+}
+
+func TestLiveTail(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+	backend.AddEvents("group", "web-1", fake.Event{Timestamp: 1, Message: "before the session"})
+	server := fake.NewLiveTailServer(backend, 0)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := testTailConfig("group", "web", true, time.Now())
+	grepv := "healthcheck"
+	config.Grepv = &grepv
+	ch, errCh := LiveTail(ctx, liveTailClient{backend, server.LogsClient()}, config, testLogger)
+
+	waitForSessions(t, backend, 1)
+	backend.AddEvents("group", "web-1", fake.Event{Timestamp: 10, Message: "one"}, fake.Event{Timestamp: 11, Message: "healthcheck"})
+	backend.AddEvents("group", "worker", fake.Event{Timestamp: 12, Message: "other stream"})
+	backend.AddEvents("group", "web-2", fake.Event{Timestamp: 13, Message: "two"})
+
+	first, second := <-ch, <-ch
+	a.Equal("one", *first.Message)
+	a.Equal("web-1", *first.LogStreamName)
+	a.Equal(int64(10), *first.Timestamp)
+	a.Equal("two", *second.Message)
+	a.NotEqual(*first.EventId, *second.EventId)
+
+	cancel()
+	for range ch {
+	}
+	a.NoError(<-errCh)
+}
+
+func TestLiveTailReconnectsOnSessionTimeout(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+	backend.AddGroup("group")
+	server := fake.NewLiveTailServer(backend, 100*time.Millisecond)
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, _ := LiveTail(ctx, liveTailClient{backend, server.LogsClient()}, testTailConfig("group", "", true, time.Now()), testLogger)
+
+	waitForSessions(t, backend, 2)
+	backend.AddEvents("group", "stream", fake.Event{Timestamp: 1, Message: "after reconnection"})
+	a.Equal("after reconnection", *(<-ch).Message)
+}
+
+func TestLiveTailUnavailable(t *testing.T) {
+	backend := fake.New()
+	server := fake.NewLiveTailServer(backend, 0)
+	defer server.Close()
+
+	ch, errCh := LiveTail(context.Background(), liveTailClient{backend, server.LogsClient()}, testTailConfig("missing", "", true, time.Now()), testLogger)
+	for range ch {
+	}
+	err := <-errCh
+	assert.True(t, errors.Is(err, ErrLiveTailUnavailable), "unexpected error %v", err)
+}
+
+func TestLiveTailInvalidGrepv(t *testing.T) {
+	backend := fake.New()
+	server := fake.NewLiveTailServer(backend, 0)
+	defer server.Close()
+
+	config := testTailConfig("group", "", true, time.Now())
+	grepv := "["
+	config.Grepv = &grepv
+	ch, errCh := LiveTail(context.Background(), liveTailClient{backend, server.LogsClient()}, config, testLogger)
+	for range ch {
+	}
+	err := <-errCh
+	assert.ErrorContains(t, err, "invalid grepv pattern")
+	assert.False(t, errors.Is(err, ErrLiveTailUnavailable), "polling would fail the same way")
+}
@@ -2,32 +2,46 @@ package cloudwatch
 
 import (
 	"context"
-	"fmt"
-	"os"
 
 	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
 )
 
-//LsGroups lists the stream groups
-//It returns a channel where stream groups are published
-func LsGroups(cwc *cloudwatchlogs.Client) <-chan *string {
-	ch := make(chan *string)
+//LsGroups lists the log groups, optionally restricted to the groups whose name starts with prefix or contains pattern
+//It returns a channel where log groups are published and a channel where an error is published if the listing fails
+//Both channels are closed once the listing is over or ctx is cancelled
+func LsGroups(ctx context.Context, cwc cloudwatchlogs.DescribeLogGroupsAPIClient, prefix *string, pattern *string) (<-chan types.LogGroup, <-chan error) {
+	ch := make(chan types.LogGroup)
+	errCh := make(chan error, 1)
 	params := &cloudwatchlogs.DescribeLogGroupsInput{}
+	if prefix != nil && *prefix != "" {
+		params.LogGroupNamePrefix = prefix
+	}
+	if pattern != nil && *pattern != "" {
+		params.LogGroupNamePattern = pattern
+	}
 
 	go func() {
+		defer close(errCh)
+		defer close(ch)
+
 		paginator := cloudwatchlogs.NewDescribeLogGroupsPaginator(cwc, params)
 		for paginator.HasMorePages() {
-			res, err := paginator.NextPage(context.TODO())
+			res, err := paginator.NextPage(ctx)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
-				close(ch)
-				os.Exit(1)
+				if ctx.Err() == nil {
+					errCh <- err
+				}
+				return
 			}
 			for _, logGroup := range res.LogGroups {
-				ch <- logGroup.LogGroupName
+				select {
+				case ch <- logGroup:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
-		close(ch)
 	}()
-	return ch
+	return ch, errCh
 }
@@ -12,26 +12,34 @@ type logStreamsPager interface {
 	NextPage(ctx context.Context, optFns ...func(*cloudwatchlogs.Options)) (*cloudwatchlogs.DescribeLogStreamsOutput, error)
 }
 
-func getStreams(paginator logStreamsPager, errCh chan error, ch chan types.LogStream) {
+func getStreams(ctx context.Context, paginator logStreamsPager, errCh chan error, ch chan types.LogStream) {
+	defer close(errCh)
+	defer close(ch)
 	for paginator.HasMorePages() {
-		res, err := paginator.NextPage(context.TODO())
+		res, err := paginator.NextPage(ctx)
 		if err != nil {
-			errCh <- err
+			select {
+			case errCh <- err:
+			case <-ctx.Done():
+			}
 			return
 		}
 
 		for _, logStream := range res.LogStreams {
-			ch <- logStream
+			select {
+			case ch <- logStream:
+			case <-ctx.Done():
+				return
+			}
 		}
 
 	}
-	close(ch)
-	close(errCh)
 }
 
 //LsStreams lists the streams of a given stream group
 //It returns a channel where the stream names are published in order of Last Ingestion Time (the first stream is the one with older Last Ingestion Time)
-func LsStreams(cwc cloudwatchlogs.DescribeLogStreamsAPIClient, groupName *string, streamName *string) (<-chan types.LogStream, <-chan error) {
+//Listing stops when ctx is cancelled. Both channels are closed once the listing is over
+func LsStreams(ctx context.Context, cwc cloudwatchlogs.DescribeLogStreamsAPIClient, groupName *string, streamName *string) (<-chan types.LogStream, <-chan error) {
 	ch := make(chan types.LogStream)
 	errCh := make(chan error)
 
@@ -41,6 +49,6 @@ func LsStreams(cwc cloudwatchlogs.DescribeLogStreamsAPIClient, groupName *string
 		params.LogStreamNamePrefix = streamName
 	}
 	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(cwc, params)
-	go getStreams(paginator, errCh, ch)
+	go getStreams(ctx, paginator, errCh, ch)
 	return ch, errCh
 }
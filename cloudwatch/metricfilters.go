@@ -0,0 +1,102 @@
+package cloudwatch
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// maxTestMessages is the maximum number of messages TestMetricFilter accepts in a single request
+const maxTestMessages = 50
+
+//LsMetricFilters lists the metric filters, optionally restricted to a log group and to the filters whose name starts with prefix
+//CloudWatch ignores prefix when no log group is given
+//It returns a channel where metric filters are published and a channel where an error is published if the listing fails
+//Both channels are closed once the listing is over or ctx is cancelled
+func LsMetricFilters(ctx context.Context, cwc cloudwatchlogs.DescribeMetricFiltersAPIClient, logGroupName *string, prefix *string) (<-chan types.MetricFilter, <-chan error) {
+	ch := make(chan types.MetricFilter)
+	errCh := make(chan error, 1)
+	params := &cloudwatchlogs.DescribeMetricFiltersInput{}
+	if logGroupName != nil && *logGroupName != "" {
+		params.LogGroupName = logGroupName
+	}
+	if prefix != nil && *prefix != "" {
+		params.FilterNamePrefix = prefix
+	}
+
+	go func() {
+		defer close(errCh)
+		defer close(ch)
+
+		paginator := cloudwatchlogs.NewDescribeMetricFiltersPaginator(cwc, params)
+		for paginator.HasMorePages() {
+			res, err := paginator.NextPage(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					errCh <- err
+				}
+				return
+			}
+			for _, filter := range res.MetricFilters {
+				select {
+				case ch <- filter:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, errCh
+}
+
+// MetricFilters manages and tests metric filters, retrying the transient errors according to RetryPolicy
+type MetricFilters struct {
+	Client MetricFiltersAPIClient
+	RetryPolicy *RetryPolicy
+	Logger      *log.Logger
+}
+
+//Put creates the metric filter, or replaces the one with the same name in the log group
+func (m *MetricFilters) Put(ctx context.Context, filter *cloudwatchlogs.PutMetricFilterInput) error {
+	return withRetry(ctx, m.RetryPolicy, m.Logger, aws.ToString(filter.FilterName), func() error {
+		_, err := m.Client.PutMetricFilter(ctx, filter)
+		return err
+	})
+}
+
+//Delete deletes the metric filter of the log group
+func (m *MetricFilters) Delete(ctx context.Context, logGroupName string, filterName string) error {
+	return withRetry(ctx, m.RetryPolicy, m.Logger, filterName, func() error {
+		_, err := m.Client.DeleteMetricFilter(ctx, &cloudwatchlogs.DeleteMetricFilterInput{LogGroupName: &logGroupName, FilterName: &filterName})
+		return err
+	})
+}
+
+//Test matches the filter pattern against the messages with TestMetricFilter, in as many requests as needed
+//It returns the records of the matching messages, with EventNumber being the 1 based position of the message in messages
+//A syntax error in the pattern is returned as an InvalidParameterException
+func (m *MetricFilters) Test(ctx context.Context, pattern string, messages []string) ([]types.MetricFilterMatchRecord, error) {
+	var matches []types.MetricFilterMatchRecord
+	for offset := 0; offset < len(messages); offset += maxTestMessages {
+		end := offset + maxTestMessages
+		if end > len(messages) {
+			end = len(messages)
+		}
+		var res *cloudwatchlogs.TestMetricFilterOutput
+		err := withRetry(ctx, m.RetryPolicy, m.Logger, "test", func() (err error) {
+			res, err = m.Client.TestMetricFilter(ctx, &cloudwatchlogs.TestMetricFilterInput{FilterPattern: &pattern, LogEventMessages: messages[offset:end]})
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range res.Matches {
+			r.EventNumber += int64(offset)
+			matches = append(matches, r)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,89 @@
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricFilters(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	backend := fake.New()
+	backend.PageSize = 1
+	backend.AddGroup("/app/orders")
+	backend.Throttle(1)
+	m := &MetricFilters{
+		Client:      backend,
+		RetryPolicy: fastRetryPolicy,
+		Logger:      log.New(io.Discard, "", 0),
+	}
+
+	for _, name := range []string{"errors", "latency", "other"} {
+		a.NoError(m.Put(ctx, &cloudwatchlogs.PutMetricFilterInput{
+			LogGroupName:  aws.String("/app/orders"),
+			FilterName:    aws.String(name),
+			FilterPattern: aws.String("ERROR"),
+			MetricTransformations: []types.MetricTransformation{
+				{MetricNamespace: aws.String("app"), MetricName: aws.String(name), MetricValue: aws.String("1")},
+			},
+		}))
+	}
+	list := func(group, prefix string) []string {
+		found, errCh := LsMetricFilters(ctx, backend, &group, &prefix)
+		var names []string
+		for f := range found {
+			names = append(names, *f.FilterName)
+		}
+		a.NoError(<-errCh)
+		return names
+	}
+	a.Equal([]string{"errors", "latency", "other"}, list("/app/orders", ""))
+	a.Equal([]string{"latency"}, list("/app/orders", "lat"))
+
+	a.NoError(m.Delete(ctx, "/app/orders", "other"))
+	a.Equal([]string{"errors", "latency"}, list("", ""))
+	var notFound *types.ResourceNotFoundException
+	a.ErrorAs(m.Delete(ctx, "/app/orders", "other"), &notFound)
+}
+
+func TestMetricFiltersTestBatches(t *testing.T) {
+	a := assert.New(t)
+	ctx := context.Background()
+	backend := fake.New()
+	m := &MetricFilters{Client: backend, Logger: log.New(io.Discard, "", 0)}
+
+	// more messages than a single TestMetricFilter request accepts
+	var messages []string
+	for i := 1; i <= 120; i++ {
+		status := "200"
+		if i%40 == 0 {
+			status = "500"
+		}
+		messages = append(messages, fmt.Sprintf("GET /orders/%d %s", i, status))
+	}
+	matches, err := m.Test(ctx, "500", messages)
+	a.NoError(err)
+	a.Len(matches, 3)
+	for i, r := range matches {
+		a.Equal(int64(40*(i+1)), r.EventNumber)
+		a.Equal(messages[r.EventNumber-1], *r.EventMessage)
+	}
+	a.Equal(3, backend.Calls("TestMetricFilter"))
+
+	matches, err = m.Test(ctx, "[method, path, status]", messages[:1])
+	a.NoError(err)
+	a.Equal(map[string]string{"$method": "GET", "$path": "/orders/1", "$status": "200"}, matches[0].ExtractedValues)
+
+	_, err = m.Test(ctx, `"unterminated`, messages[:1])
+	var invalid *types.InvalidParameterException
+	a.ErrorAs(err, &invalid)
+}
@@ -0,0 +1,186 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// The PutLogEvents limits
+const (
+	maxPutEvents     = 10000
+	maxPutBytes      = 1048576
+	putEventOverhead = 26
+	maxPutSpan       = 24 * time.Hour
+	// MaxEventSize is the maximum size in bytes of the message of an event, longer messages are truncated
+	MaxEventSize = 256*1024 - putEventOverhead
+
+	defaultPutBatchWait = time.Second
+)
+
+// PutConfig configures Put
+type PutConfig struct {
+	LogGroupName  *string
+	LogStreamName *string
+	// Create creates the log group and the log stream when they don't exist
+	Create bool
+	// BatchWait is how long the first event of a batch waits for more events before the batch is sent. One second when 0.
+	BatchWait time.Duration
+	RetryPolicy *RetryPolicy
+	// Warn, if set, is called with the warnings the user should see, e.g. events rejected by CloudWatch
+	Warn func(msg string)
+}
+
+// PutStats counts the events sent by Put
+type PutStats struct {
+	Events   int
+	Batches  int
+	Rejected int
+}
+
+func isAlreadyExists(err error) bool {
+	var exists *types.ResourceAlreadyExistsException
+	return errors.As(err, &exists)
+}
+
+//Put sends the events received on events to the log stream with PutLogEvents, until events is closed or ctx is cancelled
+//Events are sent in batches within the PutLogEvents limits, sorted by timestamp. A batch is sent when it is full
+//or BatchWait after its first event was received
+//Transient errors are retried according to the configured RetryPolicy
+func Put(ctx context.Context, cwc PutAPIClient, putConfig PutConfig, events <-chan types.InputLogEvent, logger *log.Logger) (PutStats, error) {
+	var stats PutStats
+	batchWait := putConfig.BatchWait
+	if batchWait <= 0 {
+		batchWait = defaultPutBatchWait
+	}
+	warn := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		logger.Println(msg)
+		if putConfig.Warn != nil {
+			putConfig.Warn(msg)
+		}
+	}
+	name := fmt.Sprintf("%s:%s", *putConfig.LogGroupName, *putConfig.LogStreamName)
+
+	retry := func(op func() error) error {
+		return withRetry(ctx, putConfig.RetryPolicy, logger, name, op)
+	}
+
+	if putConfig.Create {
+		err := retry(func() error {
+			_, err := cwc.CreateLogGroup(ctx, &cloudwatchlogs.CreateLogGroupInput{LogGroupName: putConfig.LogGroupName})
+			return err
+		})
+		if err != nil && !isAlreadyExists(err) {
+			return stats, err
+		}
+		err = retry(func() error {
+			_, err := cwc.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{LogGroupName: putConfig.LogGroupName, LogStreamName: putConfig.LogStreamName})
+			return err
+		})
+		if err != nil && !isAlreadyExists(err) {
+			return stats, err
+		}
+	}
+
+	putBatch := func(batch []types.InputLogEvent) error {
+		var res *cloudwatchlogs.PutLogEventsOutput
+		err := retry(func() error {
+			var err error
+			res, err = cwc.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+				LogGroupName:  putConfig.LogGroupName,
+				LogStreamName: putConfig.LogStreamName,
+				LogEvents:     batch,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		stats.Batches++
+		stats.Events += len(batch)
+		if info := res.RejectedLogEventsInfo; info != nil {
+			rejected := 0
+			for _, end := range []*int32{info.TooOldLogEventEndIndex, info.ExpiredLogEventEndIndex} {
+				if end != nil && int(*end)+1 > rejected {
+					rejected = int(*end) + 1
+				}
+			}
+			if info.TooNewLogEventStartIndex != nil {
+				rejected += len(batch) - int(*info.TooNewLogEventStartIndex)
+			}
+			stats.Rejected += rejected
+			stats.Events -= rejected
+			warn("%s: %d events rejected, they are older than the retention, older than 14 days or more than 2 hours in the future", name, rejected)
+		}
+		logger.Printf("%s: put %d events\n", name, len(batch))
+		return nil
+	}
+
+	var pending []types.InputLogEvent
+	size := 0
+	//flush sends the pending events in timestamp order, in batches spanning at most 24 hours
+	flush := func() error {
+		sort.SliceStable(pending, func(i, j int) bool {
+			return aws.ToInt64(pending[i].Timestamp) < aws.ToInt64(pending[j].Timestamp)
+		})
+		for len(pending) > 0 {
+			n := 1
+			first := aws.ToInt64(pending[0].Timestamp)
+			for n < len(pending) && aws.ToInt64(pending[n].Timestamp)-first <= int64(maxPutSpan/time.Millisecond) {
+				n++
+			}
+			if err := putBatch(pending[:n]); err != nil {
+				return err
+			}
+			pending = pending[n:]
+		}
+		pending, size = nil, 0
+		return nil
+	}
+
+	var wait <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return stats, flush()
+			}
+			msg := aws.ToString(ev.Message)
+			if len(msg) > MaxEventSize {
+				cut := MaxEventSize
+				for cut > 0 && !utf8.RuneStart(msg[cut]) {
+					cut--
+				}
+				warn("%s: event of %d bytes truncated to %d bytes", name, len(msg), cut)
+				msg = msg[:cut]
+				ev.Message = aws.String(msg)
+			}
+			if len(pending)+1 > maxPutEvents || size+len(msg)+putEventOverhead > maxPutBytes {
+				if err := flush(); err != nil {
+					return stats, err
+				}
+			}
+			pending = append(pending, ev)
+			size += len(msg) + putEventOverhead
+			if len(pending) == 1 {
+				wait = time.After(batchWait)
+			}
+		case <-wait:
+			wait = nil
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		case <-ctx.Done():
+			return stats, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+func testPutConfig(group, stream string, create bool) PutConfig {
+	return PutConfig{
+		LogGroupName:  &group,
+		LogStreamName: &stream,
+		Create:        create,
+		BatchWait:     10 * time.Millisecond,
+		RetryPolicy:   fastRetryPolicy,
+	}
+}
+
+func sendEvents(events ...types.InputLogEvent) <-chan types.InputLogEvent {
+	ch := make(chan types.InputLogEvent, len(events))
+	for _, e := range events {
+		ch <- e
+	}
+	close(ch)
+	return ch
+}
+
+func TestPutSortsAndCreates(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	backend := fake.New()
+	ev := func(ts int64, msg string) types.InputLogEvent {
+		return types.InputLogEvent{Timestamp: aws.Int64(ts), Message: aws.String(msg)}
+	}
+
+	_, err := Put(context.Background(), backend, testPutConfig("group", "stream", false), sendEvents(ev(now, "one")), testLogger)
+	a.IsType(&types.ResourceNotFoundException{}, err)
+
+	backend.Throttle(2)
+	stats, err := Put(context.Background(), backend, testPutConfig("group", "stream", true),
+		sendEvents(ev(now+2, "three"), ev(now, "one"), ev(now+1, "two"), ev(now-int64(25*time.Hour/time.Millisecond), "yesterday")), testLogger)
+	a.NoError(err)
+	a.Equal(PutStats{Events: 4, Batches: 2}, stats, "batches span at most 24 hours")
+
+	ch, errCh := Tail(context.Background(), backend, testTailConfig("group", "", false, time.Now().Add(-48*time.Hour)), testLimiter(context.Background()), testLogger)
+	a.Equal([]string{"yesterday", "one", "two", "three"}, collect(ch))
+	a.NoError(<-errCh)
+
+	var warnings []string
+	config := testPutConfig("group", "stream", true)
+	config.Warn = func(msg string) { warnings = append(warnings, msg) }
+	stats, err = Put(context.Background(), backend, config, sendEvents(ev(now-int64(15*24*time.Hour/time.Millisecond), "too old"), ev(now, "fine")), testLogger)
+	a.NoError(err)
+	a.Equal(PutStats{Events: 1, Batches: 2, Rejected: 1}, stats)
+	a.Len(warnings, 1)
+}
+
+func TestPutBatchLimits(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	backend := fake.New()
+	backend.AddStream("group", "stream")
+
+	events := make(chan types.InputLogEvent)
+	go func() {
+		defer close(events)
+		for i := 0; i < maxPutEvents+1; i++ {
+			events <- types.InputLogEvent{Timestamp: aws.Int64(now), Message: aws.String(fmt.Sprint(i))}
+		}
+		// 5 events of 256KB don't fit a single batch of 1MB
+		for i := 0; i < 5; i++ {
+			events <- types.InputLogEvent{Timestamp: aws.Int64(now), Message: aws.String(string(make([]byte, MaxEventSize+100)))}
+		}
+	}()
+	var warnings []string
+	config := testPutConfig("group", "stream", false)
+	config.BatchWait = time.Minute
+	config.Warn = func(msg string) { warnings = append(warnings, msg) }
+	stats, err := Put(context.Background(), backend, config, events, testLogger)
+	a.NoError(err)
+	a.Equal(maxPutEvents+6, stats.Events)
+	a.Equal(3, stats.Batches)
+	a.Len(warnings, 5, "longer events are truncated")
+}
@@ -0,0 +1,111 @@
+package cloudwatch
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/smithy-go"
+)
+
+// RetryPolicy controls how failed requests are retried: exponential backoff with full jitter.
+// A nil *RetryPolicy in a configuration stands for DefaultRetryPolicy.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// DefaultRetryPolicy is used when no policy is configured
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 8, BaseDelay: 250 * time.Millisecond, MaxDelay: 20 * time.Second}
+
+// backoff returns the delay before the given retry attempt (0 based): a random duration
+// between 0 and BaseDelay*2^attempt, capped to MaxDelay.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if attempt < 32 {
+		if d := p.BaseDelay << uint(attempt); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+var throttlingErrorCodes = map[string]bool{
+	"ThrottlingException":      true,
+	"Throttling":               true,
+	"ThrottledException":       true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+}
+
+// IsThrottlingError reports whether err was caused by AWS rejecting the request because of the API rate limit
+func IsThrottlingError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && throttlingErrorCodes[apiErr.ErrorCode()]
+}
+
+// IsRetryableError reports whether err is transient and the request can be retried
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsThrottlingError(err) {
+		return true
+	}
+	var unavailable *types.ServiceUnavailableException
+	if errors.As(err, &unavailable) {
+		return true
+	}
+	var aborted *types.OperationAbortedException
+	if errors.As(err, &aborted) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorFault() == smithy.FaultServer
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// withoutSDKRetries turns off the retries of the SDK for a request retried by withRetry:
+// the attempts would be multiplied, and throttling only seen once the SDK has given up
+func withoutSDKRetries(o *cloudwatchlogs.Options) {
+	o.RetryMaxAttempts = 1
+}
+
+// policyOrDefault returns the policy, or DefaultRetryPolicy when it is nil
+func policyOrDefault(policy *RetryPolicy) RetryPolicy {
+	if policy == nil {
+		return DefaultRetryPolicy
+	}
+	return *policy
+}
+
+// withRetry calls op until it succeeds, retrying the transient errors according to policy, or DefaultRetryPolicy when nil
+func withRetry(ctx context.Context, retryPolicy *RetryPolicy, logger *log.Logger, name string, op func() error) error {
+	policy := policyOrDefault(retryPolicy)
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !IsRetryableError(err) || attempt+1 >= policy.MaxAttempts {
+			return err
+		}
+		delay := policy.backoff(attempt)
+		logger.Printf("%s: attempt %d failed with %s. Retry in %s.\n", name, attempt+1, err.Error(), delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
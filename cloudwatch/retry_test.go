@@ -0,0 +1,53 @@
+package cloudwatch
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fastRetryPolicy retries with millisecond delays, to keep the tests quick
+var fastRetryPolicy = &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestErrorClassification(t *testing.T) {
+	a := assert.New(t)
+
+	throttling := &smithy.GenericAPIError{Code: "ThrottlingException", Message: "Rate exceeded"}
+	a.True(IsThrottlingError(throttling))
+	a.True(IsRetryableError(throttling))
+	a.True(IsThrottlingError(fmt.Errorf("operation error: %w", throttling)), "wrapped errors must be classified")
+
+	a.True(IsRetryableError(&types.ServiceUnavailableException{Message: aws.String("unavailable")}))
+	a.False(IsThrottlingError(&types.ServiceUnavailableException{Message: aws.String("unavailable")}))
+
+	a.False(IsRetryableError(&types.ResourceNotFoundException{Message: aws.String("not found")}))
+	a.False(IsRetryableError(&types.InvalidParameterException{Message: aws.String("invalid")}))
+	a.False(IsRetryableError(errors.New("boom")))
+	a.False(IsRetryableError(nil))
+}
+
+func TestBackoffIsCappedAndJittered(t *testing.T) {
+	a := assert.New(t)
+	p := RetryPolicy{MaxAttempts: 10, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		d := p.backoff(attempt)
+		a.GreaterOrEqual(d, time.Duration(0))
+		a.LessOrEqual(d, time.Second)
+		if attempt == 0 {
+			a.LessOrEqual(d, 100*time.Millisecond)
+		}
+	}
+}
+
+func TestPolicyOrDefault(t *testing.T) {
+	a := assert.New(t)
+	a.Equal(DefaultRetryPolicy, policyOrDefault(nil))
+	a.Equal(*fastRetryPolicy, policyOrDefault(fastRetryPolicy))
+}
@@ -5,10 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"os"
 	"regexp"
 	"sort"
-	"strings"
 	"sync"
 	"time"
 
@@ -33,7 +31,7 @@ func (s *logStreamsType) get() []string {
 	return s.groupStreams
 }
 
-func makeParams(logGroupName string, streamNames []string, _ *string,
+func makeParams(logGroupName string, streamNames []string, logStreamNamePrefix *string,
 	startTimeInMillis int64, endTimeInMillis int64,
 	grep *string, follow *bool) *cloudwatchlogs.FilterLogEventsInput {
 
@@ -47,10 +45,9 @@ func makeParams(logGroupName string, streamNames []string, _ *string,
 
 	if streamNames != nil {
 		params.LogStreamNames = streamNames
+	} else if logStreamNamePrefix != nil && *logStreamNamePrefix != "" {
+		params.LogStreamNamePrefix = logStreamNamePrefix
 	}
-	// if logStreamNamePrefix != nil {
-	// 	params.LogStreamNamePrefix = logStreamNamePrefix
-	// }
 
 	if !*follow && endTimeInMillis != 0 {
 		params.EndTime = &endTimeInMillis
@@ -58,8 +55,15 @@ func makeParams(logGroupName string, streamNames []string, _ *string,
 	return params
 }
 
+//shard is the events of a FilterLogEvents paginator not published yet
+type shard struct {
+	paginator *cloudwatchlogs.FilterLogEventsPaginator
+	events    []types.FilteredLogEvent
+}
+
 type fs func() (<-chan types.LogStream, <-chan error)
 
+//sortLogStreamsByMostRecentEvent sorts the streams by last ingestion time, the most recently ingested stream first
 func sortLogStreamsByMostRecentEvent(logStream []types.LogStream) []types.LogStream {
 	sort.SliceStable(logStream, func(i, j int) bool {
 		var streamALastIngestionTime int64 = 0
@@ -73,18 +77,24 @@ func sortLogStreamsByMostRecentEvent(logStream []types.LogStream) []types.LogStr
 			streamBLastIngestionTime = *ingestionTime
 		}
 
-		return streamALastIngestionTime < streamBLastIngestionTime
+		return streamALastIngestionTime > streamBLastIngestionTime
 	})
-	if len(logStream) > 100 {
-		logStream = logStream[len(logStream)-100:]
-	}
 	return logStream
 }
 
-func initialiseStreams(retry *bool, idle chan<- bool, logStreams *logStreamsType, fetchStreams fs, logger *log.Logger) error {
-	executionCh := make(chan time.Time, 1)
-	executionCh <- time.Now()
+//shardStreams splits the stream names in chunks accepted by a single FilterLogEvents request
+func shardStreams(streamNames []string) [][]string {
+	var shards [][]string
+	for len(streamNames) > maxStreamsPerRequest {
+		shards = append(shards, streamNames[:maxStreamsPerRequest])
+		streamNames = streamNames[maxStreamsPerRequest:]
+	}
+	return append(shards, streamNames)
+}
 
+//initialiseStreams resolves the streams to tail, the ones returned by fetchStreams whose name matches pattern (all of them if pattern is nil),
+//and refreshes them every 5 seconds
+func initialiseStreams(ctx context.Context, retry *bool, idle chan<- bool, logStreams *logStreamsType, fetchStreams fs, pattern *regexp.Regexp, logger *log.Logger) error {
 	getTargetStreams := func() ([]string, error) {
 		var streams []types.LogStream
 		foundStreams, errCh := fetchStreams()
@@ -98,20 +108,21 @@ func initialiseStreams(retry *bool, idle chan<- bool, logStreams *logStreamsType
 				}
 			case stream, ok := <-foundStreams: //TODO improve performance
 				if ok {
-					streams = append(streams, stream)
+					if pattern == nil || pattern.MatchString(*stream.LogStreamName) {
+						streams = append(streams, stream)
+					}
 				} else {
 					break outerLoop
 				}
+			case <-ctx.Done():
+				return nil, ctx.Err()
 			case <-time.After(5 * time.Second):
 				//TODO handle deadlock scenario
 			}
 		}
-		//FilterLogEventPages won't take more than 100 stream names, the most one with most recent activities will be used.
+		//the most recently active streams come first, so they are the ones kept if the set has to be capped
 		logger.Println("streams found:", len(streams))
-
-		if len(streams) >= 100 {
-			streams = sortLogStreamsByMostRecentEvent(streams)
-		}
+		streams = sortLogStreamsByMostRecentEvent(streams)
 
 		var streamNames []string
 		for _, s := range streams {
@@ -120,138 +131,337 @@ func initialiseStreams(retry *bool, idle chan<- bool, logStreams *logStreamsType
 		return streamNames, nil
 	}
 
-	for range executionCh {
+	for {
 		s, e := getTargetStreams()
-		if e != nil {
-			rnf := &types.ResourceNotFoundException{}
-			if errors.As(e, &rnf) && *retry {
-				logger.Println("log group not available but retry flag. Re-check in 150 milliseconds.")
-				timer := time.After(time.Millisecond * 150)
-				executionCh <- <-timer
-			} else {
-				return e
-			}
-		} else {
+		if e == nil {
 			logStreams.reset(s)
-
 			idle <- true
-			close(executionCh)
+			break
+		}
+		rnf := &types.ResourceNotFoundException{}
+		if !errors.As(e, &rnf) || !*retry {
+			return e
+		}
+		logger.Println("log group not available but retry flag. Re-check in 150 milliseconds.")
+		select {
+		case <-time.After(time.Millisecond * 150):
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 	//refresh streams list every 5 secs
 	t := time.NewTicker(time.Second * 5)
 	go func() {
-		for range t.C {
-			s, _ := getTargetStreams()
-			if s != nil {
-				logStreams.reset(s)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s, _ := getTargetStreams()
+				if s != nil {
+					logStreams.reset(s)
+				}
+			case <-ctx.Done():
+				return
 			}
 		}
 	}()
 	return nil
 }
 
+// PollStats describes the outcome of a single poll of a tailed log group
+type PollStats struct {
+	// Events is the number of events returned by the poll
+	Events int
+	// Throttled is true if any request of the poll hit the API rate limit
+	Throttled bool
+	// Requests is the number of FilterLogEvents requests sent by the poll, retries and every shard's pages included
+	Requests int
+}
+
+// Checkpoint is the position reached while tailing a target: the timestamp of the most recent event
+// and the ids of the events seen with that timestamp
+type Checkpoint struct {
+	Timestamp int64    `json:"timestamp"`
+	EventIDs  []string `json:"eventIds"`
+}
+
+const (
+	//maxStreamsPerRequest is the maximum number of stream names accepted by FilterLogEvents
+	maxStreamsPerRequest = 100
+	//DefaultMaxStreams is the default maximum number of streams tailed by name
+	DefaultMaxStreams = 500
+)
+
 type TailConfig struct {
 	LogGroupName  *string
 	LogStreamName *string
-	Follow        *bool
-	Retry         *bool
-	StartTime     *time.Time
-	EndTime       *time.Time
-	Grep          *string
-	Grepv         *string
+	// LogStreamPattern, if set, restricts the tailed streams to the ones whose name matches it.
+	// LogStreamName is then only used as the prefix narrowing the listing of the streams.
+	LogStreamPattern *regexp.Regexp
+	Follow           *bool
+	Retry            *bool
+	StartTime        *time.Time
+	EndTime          *time.Time
+	Grep             *string
+	Grepv            *string
+	// OnPoll, if set, is called at the end of every poll triggered by the limiter
+	OnPoll func(PollStats)
+	RetryPolicy *RetryPolicy
+	// Resume, if set, restarts tailing from the checkpoint rather than StartTime.
+	// Events already seen at the checkpoint timestamp are not published again.
+	Resume *Checkpoint
+	// MaxStreams caps the number of streams tailed by name, each request covering up to 100 of them.
+	// Past it the streams are selected by LogStreamNamePrefix instead or, with a LogStreamPattern,
+	// only the most recently active streams are tailed. DefaultMaxStreams is used when 0.
+	MaxStreams int
+	// Warn, if set, is called with the warnings the user should see, e.g. streams not being tailed
+	Warn func(msg string)
+}
+
+//withDefaults returns a copy of the config where the nil fields are set to their zero value
+func (c TailConfig) withDefaults() TailConfig {
+	for _, s := range []**string{&c.LogGroupName, &c.LogStreamName, &c.Grep, &c.Grepv} {
+		if *s == nil {
+			*s = new(string)
+		}
+	}
+	for _, b := range []**bool{&c.Follow, &c.Retry} {
+		if *b == nil {
+			*b = new(bool)
+		}
+	}
+	for _, t := range []**time.Time{&c.StartTime, &c.EndTime} {
+		if *t == nil {
+			*t = &time.Time{}
+		}
+	}
+	return c
 }
 
 //Tail tails the given stream names in the specified log group name
 //To tail all the available streams logStreamName has to be '*'
-//It returns a channel where logs line are published
+//It returns a channel where logs line are published and a channel where a fatal error is published
+//Transient errors are retried according to the configured RetryPolicy
+//The nil fields of tailConfig are their zero value, a zero StartTime tails from the first event
+//An invalid Grepv is published as an error
 //Unless the follow flag is true the channel is closed once there are no more events available
-func Tail(cwc *cloudwatchlogs.Client,
+//Both channels are closed when tailing stops or when ctx is cancelled, the goroutines started by Tail stop with them
+func Tail(ctx context.Context,
+	cwc TailAPIClient,
 	tailConfig TailConfig,
 	limiter <-chan time.Time,
-	logger *log.Logger) (<-chan types.FilteredLogEvent, error) {
+	logger *log.Logger) (<-chan types.FilteredLogEvent, <-chan error) {
+
+	tailConfig = tailConfig.withDefaults()
+	ch := make(chan types.FilteredLogEvent, 1000)
+	errCh := make(chan error, 1)
+	idle := make(chan bool, 1)
 
-	lastSeenTimestamp := tailConfig.StartTime.Unix() * 1000
+	var grepv *regexp.Regexp
+	if *tailConfig.Grepv != "" {
+		var err error
+		if grepv, err = regexp.Compile(*tailConfig.Grepv); err != nil {
+			errCh <- fmt.Errorf("invalid grepv pattern %q: %w", *tailConfig.Grepv, err)
+			close(errCh)
+			close(ch)
+			return ch, errCh
+		}
+	}
+
+	// the cache janitor and the streams refresh stop when tailing does, not only when the caller cancels ctx
+	ctx, cancel := context.WithCancel(ctx)
+
+	var lastSeenTimestamp int64
+	if !tailConfig.StartTime.IsZero() {
+		lastSeenTimestamp = tailConfig.StartTime.Unix() * 1000
+	}
 	var endTimeInMillis int64
 	if !tailConfig.EndTime.IsZero() {
 		endTimeInMillis = tailConfig.EndTime.Unix() * 1000
 	}
 
-	ch := make(chan types.FilteredLogEvent, 1000)
-	idle := make(chan bool, 1)
-
 	ttl := 60 * time.Second
-	cache := createCache(ttl, defaultPurgeFreq, logger)
+	cache := createCache(ctx, ttl, defaultPurgeFreq, logger)
+
+	if tailConfig.Resume != nil {
+		lastSeenTimestamp = tailConfig.Resume.Timestamp
+		for _, id := range tailConfig.Resume.EventIDs {
+			cache.Add(id, tailConfig.Resume.Timestamp)
+		}
+		logger.Printf("%s: resuming from %d with %d seen events\n", *tailConfig.LogGroupName, lastSeenTimestamp, len(tailConfig.Resume.EventIDs))
+	}
 
 	logStreams := &logStreamsType{}
 
-	if tailConfig.LogStreamName != nil && *tailConfig.LogStreamName != "" {
+	hasPrefix := tailConfig.LogStreamName != nil && *tailConfig.LogStreamName != ""
+	if hasPrefix || tailConfig.LogStreamPattern != nil {
 		fetchStreams := func() (<-chan types.LogStream, <-chan error) {
-			return LsStreams(cwc, tailConfig.LogGroupName, tailConfig.LogStreamName)
+			return LsStreams(ctx, cwc, tailConfig.LogGroupName, tailConfig.LogStreamName)
 		}
-		err := initialiseStreams(tailConfig.Retry, idle, logStreams, fetchStreams, logger)
+		err := initialiseStreams(ctx, tailConfig.Retry, idle, logStreams, fetchStreams, tailConfig.LogStreamPattern, logger)
 		if err != nil {
-			// logger.Println("got an error back:", err)
-			return nil, err
+			cancel()
+			errCh <- err
+			close(errCh)
+			close(ch)
+			return ch, errCh
 		}
 	} else {
 		idle <- true
 	}
-	re := regexp.MustCompile(*tailConfig.Grepv)
+
+	nextPage := func(paginator *cloudwatchlogs.FilterLogEventsPaginator, stats *PollStats) (*cloudwatchlogs.FilterLogEventsOutput, error) {
+		var res *cloudwatchlogs.FilterLogEventsOutput
+		err := withRetry(ctx, tailConfig.RetryPolicy, logger, *tailConfig.LogGroupName, func() error {
+			stats.Requests++
+			var err error
+			res, err = paginator.NextPage(ctx, withoutSDKRetries)
+			if IsThrottlingError(err) {
+				stats.Throttled = true
+			}
+			return err
+		})
+		return res, err
+	}
+
+	maxStreams := tailConfig.MaxStreams
+	if maxStreams <= 0 {
+		maxStreams = DefaultMaxStreams
+	}
+	warn := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		logger.Println(msg)
+		if tailConfig.Warn != nil {
+			tailConfig.Warn(msg)
+		}
+	}
+	skipped, byPrefix := 0, false
+	//requests returns the FilterLogEvents requests of a poll: one per shard of at most 100 stream names
+	requests := func() []*cloudwatchlogs.FilterLogEventsInput {
+		streamNames := logStreams.get()
+		if tailConfig.LogStreamPattern != nil && len(streamNames) == 0 {
+			//no stream matches the pattern yet
+			return nil
+		}
+		if streamNames == nil || (hasPrefix && tailConfig.LogStreamPattern == nil && len(streamNames) > maxStreams) {
+			if streamNames != nil && !byPrefix {
+				logger.Printf("%s: %d streams, more than %d, selecting them by prefix\n", *tailConfig.LogGroupName, len(streamNames), maxStreams)
+			}
+			byPrefix = streamNames != nil
+			return []*cloudwatchlogs.FilterLogEventsInput{makeParams(*tailConfig.LogGroupName, nil, tailConfig.LogStreamName, lastSeenTimestamp, endTimeInMillis, tailConfig.Grep, tailConfig.Follow)}
+		}
+		byPrefix = false
+		if len(streamNames) > maxStreams {
+			if n := len(streamNames) - maxStreams; n != skipped {
+				warn("%s: %d streams match, only the %d most recently active are tailed, %d are skipped", *tailConfig.LogGroupName, len(streamNames), maxStreams, n)
+				skipped = n
+			}
+			streamNames = streamNames[:maxStreams]
+		} else {
+			skipped = 0
+		}
+		var params []*cloudwatchlogs.FilterLogEventsInput
+		for _, shard := range shardStreams(streamNames) {
+			params = append(params, makeParams(*tailConfig.LogGroupName, shard, nil, lastSeenTimestamp, endTimeInMillis, tailConfig.Grep, tailConfig.Follow))
+		}
+		return params
+	}
+
+	publish := func(event types.FilteredLogEvent) error {
+		if grepv != nil && grepv.MatchString(*event.Message) {
+			return nil
+		}
+		if cache.Has(*event.EventId) {
+			logger.Printf("%s already seen\n", *event.EventId)
+			return nil
+		}
+		eventTimestamp := *event.Timestamp
+		if eventTimestamp != lastSeenTimestamp {
+			if eventTimestamp < lastSeenTimestamp {
+				logger.Printf("old event:%s, ev-ts:%d, last-ts:%d, cache-size:%d \n", *event.Message, eventTimestamp, lastSeenTimestamp, cache.Size())
+			}
+			lastSeenTimestamp = eventTimestamp
+		}
+		cache.Add(*event.EventId, *event.Timestamp)
+		select {
+		case ch <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	//poll reads all the shards, merging their events in timestamp order
+	poll := func(stats *PollStats) error {
+		var shards []*shard
+		for _, params := range requests() {
+			shards = append(shards, &shard{paginator: cloudwatchlogs.NewFilterLogEventsPaginator(cwc, params)})
+		}
+		for {
+			var next *shard
+			for _, sh := range shards {
+				for len(sh.events) == 0 && sh.paginator.HasMorePages() {
+					res, err := nextPage(sh.paginator, stats)
+					if err != nil {
+						return err
+					}
+					stats.Events += len(res.Events)
+					sh.events = res.Events
+				}
+				if len(sh.events) > 0 && (next == nil || *sh.events[0].Timestamp < *next.events[0].Timestamp) {
+					next = sh
+				}
+			}
+			if next == nil {
+				return nil
+			}
+			event := next.events[0]
+			next.events = next.events[1:]
+			if err := publish(event); err != nil {
+				return err
+			}
+		}
+	}
+
 	go func() {
-		for range limiter {
+		defer cancel()
+		defer close(errCh)
+		defer close(ch)
+
+		for {
+			select {
+			case _, ok := <-limiter:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+
 			select {
 			case <-idle:
-				logParam := makeParams(*tailConfig.LogGroupName, logStreams.get(), tailConfig.LogStreamName, lastSeenTimestamp, endTimeInMillis, tailConfig.Grep, tailConfig.Follow)
-				paginator := cloudwatchlogs.NewFilterLogEventsPaginator(cwc, logParam)
-				for paginator.HasMorePages() {
-					res, err := paginator.NextPage(context.TODO())
-					if err != nil {
-						logger.Println(err.Error())
-						if strings.Contains(err.Error(), "ThrottlingException") { //could not find the native error...fmt.
-							logger.Printf("Rate exceeded for %s. Wait for 250ms then retry.\n", *tailConfig.LogGroupName)
-
-							//Wait and fire request again. 1 Retry allowed.
-							time.Sleep(250 * time.Millisecond)
-							res, err = paginator.NextPage(context.TODO())
-							if err != nil {
-								fmt.Fprintln(os.Stderr, err.Error())
-								os.Exit(1)
-							}
-						} else {
-							fmt.Fprintln(os.Stderr, err.Error())
-							os.Exit(1)
-						}
-					}
-					for _, event := range res.Events {
-						if *tailConfig.Grepv == "" || !re.MatchString(*event.Message) {
-							if !cache.Has(*event.EventId) {
-								eventTimestamp := *event.Timestamp
-
-								if eventTimestamp != lastSeenTimestamp {
-									if eventTimestamp < lastSeenTimestamp {
-										logger.Printf("old event:%s, ev-ts:%d, last-ts:%d, cache-size:%d \n", *event.Message, eventTimestamp, lastSeenTimestamp, cache.Size())
-									}
-									lastSeenTimestamp = eventTimestamp
-								}
-								cache.Add(*event.EventId, *event.Timestamp)
-								ch <- event
-							} else {
-								logger.Printf("%s already seen\n", *event.EventId)
-							}
-						}
+				var stats PollStats
+				err := poll(&stats)
+				if tailConfig.OnPoll != nil {
+					tailConfig.OnPoll(stats)
+				}
+				if err != nil {
+					if ctx.Err() == nil {
+						errCh <- err
 					}
-
+					return
 				}
 				if !*tailConfig.Follow {
-					close(ch)
-				} else {
-					idle <- true
+					return
 				}
+				idle <- true
 			case <-time.After(5 * time.Millisecond):
 				logger.Printf("%s still tailing, Skip polling.\n", *tailConfig.LogGroupName)
+				if tailConfig.OnPoll != nil {
+					tailConfig.OnPoll(PollStats{})
+				}
 			}
 		}
 	}()
-	return ch, nil
+	return ch, errCh
 }
@@ -0,0 +1,331 @@
+package cloudwatch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = log.New(io.Discard, "", log.LstdFlags)
+
+func testTailConfig(group string, prefix string, follow bool, start time.Time) TailConfig {
+	retry := false
+	grep := ""
+	grepv := ""
+	end := time.Time{}
+	return TailConfig{
+		LogGroupName:  &group,
+		LogStreamName: &prefix,
+		Follow:        &follow,
+		Retry:         &retry,
+		StartTime:     &start,
+		EndTime:       &end,
+		Grep:          &grep,
+		Grepv:         &grepv,
+		RetryPolicy:   fastRetryPolicy,
+	}
+}
+
+func testLimiter(ctx context.Context) <-chan time.Time {
+	limiter := make(chan time.Time, 1)
+	go func() {
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case t := <-ticker.C:
+				select {
+				case limiter <- t:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return limiter
+}
+
+func collect(ch <-chan types.FilteredLogEvent) []string {
+	var messages []string
+	for ev := range ch {
+		messages = append(messages, *ev.Message)
+	}
+	return messages
+}
+
+func TestTailAgainstFakeBackend(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.PageSize = 2
+	backend.AddEvents("group", "web-1", fake.Event{Timestamp: ts + 1, Message: "one"}, fake.Event{Timestamp: ts + 3, Message: "three"})
+	backend.AddEvents("group", "web-2", fake.Event{Timestamp: ts + 2, Message: "two"}, fake.Event{Timestamp: ts + 4, Message: "four"})
+	backend.AddEvents("group", "worker", fake.Event{Timestamp: ts + 5, Message: "worker"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, errCh := Tail(ctx, backend, testTailConfig("group", "", false, start), testLimiter(ctx), testLogger)
+	a.Equal([]string{"one", "two", "three", "four", "worker"}, collect(ch))
+	a.NoError(<-errCh)
+
+	ch, errCh = Tail(ctx, backend, testTailConfig("group", "web", false, start), testLimiter(ctx), testLogger)
+	a.Equal([]string{"one", "two", "three", "four"}, collect(ch))
+	a.NoError(<-errCh)
+}
+
+func TestTailStopsItsGoroutinesWhenDone(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+	backend := fake.New()
+	backend.AddEvents("group", "web-1", fake.Event{Timestamp: ts, Message: "one"})
+
+	limiterCtx, stopLimiter := context.WithCancel(context.Background())
+	limiter := testLimiter(limiterCtx)
+	before := runtime.NumGoroutine()
+
+	// the caller's ctx is never cancelled: the goroutines have to stop because tailing is over
+	ch, errCh := Tail(context.Background(), backend, testTailConfig("group", "web", false, start), limiter, testLogger)
+	a.Equal([]string{"one"}, collect(ch))
+	a.NoError(<-errCh)
+	stopLimiter()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before-1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	a.LessOrEqual(runtime.NumGoroutine(), before-1, "the limiter goroutine stopped, none of Tail's is left")
+}
+
+func TestTailFollowDeliversNewEventsOnce(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.AddEvents("group", "stream", fake.Event{Timestamp: ts, Message: "first"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, errCh := Tail(ctx, backend, testTailConfig("group", "", true, start), testLimiter(ctx), testLogger)
+
+	a.Equal("first", *(<-ch).Message)
+	backend.AddEvents("group", "stream", fake.Event{Timestamp: ts, Message: "same timestamp"}, fake.Event{Timestamp: ts + 10, Message: "later"})
+	a.Equal("same timestamp", *(<-ch).Message)
+	a.Equal("later", *(<-ch).Message)
+
+	select {
+	case ev := <-ch:
+		a.Fail("unexpected duplicate", *ev.Message)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	for range ch {
+	}
+	a.NoError(<-errCh, "cancellation is not an error")
+}
+
+func TestTailRetriesWhenThrottled(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.AddEvents("group", "stream", fake.Event{Timestamp: ts, Message: "hello"})
+	backend.Throttle(3)
+
+	var mu sync.Mutex
+	var polls []PollStats
+	config := testTailConfig("group", "", false, start)
+	config.OnPoll = func(s PollStats) {
+		mu.Lock()
+		defer mu.Unlock()
+		polls = append(polls, s)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, errCh := Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+
+	a.Equal([]string{"hello"}, collect(ch))
+	a.NoError(<-errCh)
+	a.Equal(4, backend.Calls("FilterLogEvents"))
+	mu.Lock()
+	defer mu.Unlock()
+	a.Equal([]PollStats{{Events: 1, Throttled: true, Requests: 4}}, polls)
+}
+
+func TestTailReportsFatalErrors(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, errCh := Tail(ctx, backend, testTailConfig("missing", "", true, time.Now()), testLimiter(ctx), testLogger)
+
+	a.Empty(collect(ch))
+	err := <-errCh
+	a.Error(err)
+	a.IsType(&types.ResourceNotFoundException{}, err)
+
+	backend.Throttle(10)
+	ch, errCh = Tail(ctx, backend, testTailConfig("missing", "", true, time.Now()), testLimiter(ctx), testLogger)
+	a.Empty(collect(ch))
+	err = <-errCh
+	a.True(IsThrottlingError(err), fmt.Sprintf("retries should give up after MaxAttempts: %v", err))
+}
+
+func TestTailConfigNilFieldsAreZeroValues(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+	backend.AddEvents("group", "stream", fake.Event{Timestamp: 1, Message: "first"}, fake.Event{Timestamp: 2, Message: "second"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	group := "group"
+	ch, errCh := Tail(ctx, backend, TailConfig{LogGroupName: &group}, testLimiter(ctx), testLogger)
+	a.Equal([]string{"first", "second"}, collect(ch), "no StartTime tails from the first event")
+	a.NoError(<-errCh)
+
+	grepv := "["
+	ch, errCh = Tail(ctx, backend, TailConfig{LogGroupName: &group, Grepv: &grepv}, testLimiter(ctx), testLogger)
+	a.Empty(collect(ch))
+	a.ErrorContains(<-errCh, "invalid grepv pattern")
+}
+
+func TestTailResumesFromCheckpoint(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.AddEvents("group", "stream",
+		fake.Event{Timestamp: ts, Message: "old"},
+		fake.Event{Timestamp: ts + 5, Message: "seen"},
+		fake.Event{Timestamp: ts + 5, Message: "not seen"},
+		fake.Event{Timestamp: ts + 6, Message: "new"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var seenID string
+	ch, errCh := Tail(ctx, backend, testTailConfig("group", "", false, start), testLimiter(ctx), testLogger)
+	for ev := range ch {
+		if *ev.Message == "seen" {
+			seenID = *ev.EventId
+		}
+	}
+	a.NoError(<-errCh)
+
+	config := testTailConfig("group", "", false, start)
+	config.Resume = &Checkpoint{Timestamp: ts + 5, EventIDs: []string{seenID}}
+	ch, errCh = Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+	a.Equal([]string{"not seen", "new"}, collect(ch))
+	a.NoError(<-errCh)
+}
+
+func TestLsGroupsPrefixAndPattern(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+	backend.PageSize = 2
+	for _, g := range []string{"/aws/lambda/orders", "/aws/lambda/payments", "/ecs/orders", "/ecs/web"} {
+		backend.AddGroup(g)
+	}
+
+	names := func(prefix, pattern string) []string {
+		groups, errCh := LsGroups(context.Background(), backend, &prefix, &pattern)
+		var res []string
+		for g := range groups {
+			res = append(res, *g.LogGroupName)
+		}
+		a.NoError(<-errCh)
+		return res
+	}
+
+	a.Len(names("", ""), 4)
+	a.Equal([]string{"/aws/lambda/orders", "/aws/lambda/payments"}, names("/aws/", ""))
+	a.Equal([]string{"/aws/lambda/orders", "/ecs/orders"}, names("", "ORDERS"))
+}
+
+func TestTailShardsLargeStreamSets(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.PageSize = 1000
+	var expected []string
+	for i := 0; i < 250; i++ {
+		msg := fmt.Sprintf("event %d", i)
+		// interleave the streams of the different shards
+		backend.AddEvents("group", fmt.Sprintf("web-%03d", (i*7)%250), fake.Event{Timestamp: ts + int64(i), IngestionTime: ts + int64(i), Message: msg})
+		expected = append(expected, msg)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch, errCh := Tail(ctx, backend, testTailConfig("group", "web", false, start), testLimiter(ctx), testLogger)
+	a.Equal(expected, collect(ch))
+	a.NoError(<-errCh)
+	a.Equal(3, backend.Calls("FilterLogEvents"))
+
+	var warnings []string
+	config := testTailConfig("group", "web", false, start)
+	config.MaxStreams = 100
+	config.Warn = func(msg string) { warnings = append(warnings, msg) }
+	ch, errCh = Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+	a.Equal(expected, collect(ch))
+	a.NoError(<-errCh)
+	a.Equal(4, backend.Calls("FilterLogEvents"), "past MaxStreams the streams are selected by prefix in a single request")
+	a.Empty(warnings)
+}
+
+func TestTailStreamPattern(t *testing.T) {
+	a := assert.New(t)
+	start := time.Now().Add(-time.Hour)
+	ts := start.UnixNano() / int64(time.Millisecond)
+
+	backend := fake.New()
+	backend.AddEvents("group", "ecs/web/1", fake.Event{Timestamp: ts + 1, Message: "web"})
+	backend.AddEvents("group", "ecs/api/1", fake.Event{Timestamp: ts + 2, Message: "api"})
+	backend.AddEvents("group", "ecs/api/2", fake.Event{Timestamp: ts + 3, Message: "api 2"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := testTailConfig("group", "", false, start)
+	config.LogStreamPattern = regexp.MustCompile("/api/")
+	ch, errCh := Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+	a.Equal([]string{"api", "api 2"}, collect(ch))
+	a.NoError(<-errCh)
+
+	config.LogStreamPattern = regexp.MustCompile("/worker/")
+	ch, errCh = Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+	a.Empty(collect(ch), "no stream matching the pattern means no events")
+	a.NoError(<-errCh)
+
+	var warnings []string
+	config.LogStreamPattern = regexp.MustCompile("^ecs/")
+	config.MaxStreams = 2
+	config.Warn = func(msg string) { warnings = append(warnings, msg) }
+	ch, errCh = Tail(ctx, backend, config, testLimiter(ctx), testLogger)
+	a.Len(collect(ch), 2)
+	a.NoError(<-errCh)
+	a.Len(warnings, 1)
+}
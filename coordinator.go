@@ -1,75 +1,247 @@
 package main
 
 import (
-	"container/ring"
 	"log"
 	"sync"
 	"time"
+
+	"github.com/lucagrulla/cw/cloudwatch"
 )
 
+const (
+	//AWS API accepts 5 reqs/sec for account
+	defaultRate           = 5.0
+	defaultMaxConcurrency = 4
+	// minRate is the floor the request rate is allowed to back off to when throttled.
+	minRate = 0.5
+	// idlePenalty delays the next poll of a target that returned no events on its last poll,
+	// giving priority to the targets that are actively producing events.
+	idlePenalty   = 2 * time.Second
+	schedulerTick = 25 * time.Millisecond
+)
+
+type clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+type tailTarget struct {
+	trigger  chan<- time.Time
+	inFlight bool
+	active   bool
+	lastPoll time.Time
+}
+
+// due returns the time from which the target is eligible for its next poll.
+func (t *tailTarget) due() time.Time {
+	if t.active {
+		return t.lastPoll
+	}
+	return t.lastPoll.Add(idlePenalty)
+}
+
+// tailCoordinator shares a budget of FilterLogEvents requests across all the tailed groups.
+// The budget is a token bucket refilled at rate requests per second; every trigger sent to a
+// target consumes a token, and a poll that took more than one request is charged the others
+// when done. Targets which returned events on their last poll are favoured over idle ones,
+// and the rate is halved every time a poll is throttled, then slowly recovered.
 type tailCoordinator struct {
-	targets *ring.Ring
-	sync.RWMutex
-	log *log.Logger
+	targets []*tailTarget
+	sync.Mutex
+	log            *log.Logger
+	rate           float64
+	maxConcurrency int
+	clock          clock
+
+	currentRate float64
+	tokens      float64
+	lastRefill  time.Time
+	inFlight    int
+	// holds counts the sources that may still add targets: the scheduler keeps running while there are any
+	holds int
 }
 
-func (f *tailCoordinator) start(targets []chan<- time.Time) {
-	f.targets = ring.New(len(targets))
-	for i := 0; i < f.targets.Len(); i++ {
-		f.targets.Value = targets[i]
-		f.targets = f.targets.Next()
+func (f *tailCoordinator) init() {
+	if f.rate <= 0 {
+		f.rate = defaultRate
+	}
+	if f.maxConcurrency <= 0 {
+		f.maxConcurrency = defaultMaxConcurrency
 	}
+	if f.clock == nil {
+		f.clock = realClock{}
+	}
+	f.currentRate = f.rate
+	f.tokens = 1
+	f.lastRefill = f.clock.Now()
+}
 
-	//AWS API accepts 5 reqs/sec for account
-	ticker := time.NewTicker(250 * time.Millisecond)
+func (f *tailCoordinator) burst() float64 {
+	b := float64(f.maxConcurrency)
+	if f.currentRate < b {
+		b = f.currentRate
+	}
+	if b < 1 {
+		b = 1
+	}
+	return b
+}
+
+func (f *tailCoordinator) refill(now time.Time) {
+	elapsed := now.Sub(f.lastRefill).Seconds()
+	f.lastRefill = now
+	if elapsed <= 0 {
+		return
+	}
+	f.tokens += elapsed * f.currentRate
+	if b := f.burst(); f.tokens > b {
+		f.tokens = b
+	}
+}
+
+// next returns the target to poll next: the one that is due first amongst the targets not already polling.
+func (f *tailCoordinator) next() *tailTarget {
+	var candidate *tailTarget
+	for _, t := range f.targets {
+		if t.inFlight {
+			continue
+		}
+		if candidate == nil || t.due().Before(candidate.due()) {
+			candidate = t
+		}
+	}
+	return candidate
+}
+
+func (f *tailCoordinator) find(c chan<- time.Time) (int, *tailTarget) {
+	for idx, t := range f.targets {
+		if t.trigger == c {
+			return idx, t
+		}
+	}
+	return -1, nil
+}
+
+// schedule spends the available tokens triggering the next targets to poll.
+// It returns false when there are no targets left and none can be added anymore.
+func (f *tailCoordinator) schedule() bool {
+	f.Lock()
+	defer f.Unlock()
+
+	if len(f.targets) == 0 {
+		return f.holds > 0
+	}
+	now := f.clock.Now()
+	f.refill(now)
+	for f.tokens >= 1 && f.inFlight < f.maxConcurrency {
+		t := f.next()
+		if t == nil {
+			break
+		}
+		select {
+		case t.trigger <- now:
+			t.inFlight = true
+			f.inFlight++
+			f.tokens--
+		default:
+			f.log.Println("coordinator: trigger channel is full, skip target.")
+			return true
+		}
+	}
+	return true
+}
+
+// start runs the scheduler. Targets are registered with add.
+func (f *tailCoordinator) start() {
+	f.Lock()
+	f.init()
+	f.Unlock()
+
+	ticker := time.NewTicker(schedulerTick)
 	go func() {
+		defer ticker.Stop()
 		for range ticker.C {
-			if f.targets == nil {
-				f.log.Println("coordinator: ring buffer is empty, exiting scheduler.")
+			if !f.schedule() {
+				f.log.Println("coordinator: no targets left, exiting scheduler.")
 				return
 			}
-			f.Lock()
-			x := f.targets.Value.(chan<- time.Time)
-			x <- time.Now()
-			f.targets = f.targets.Next()
-			f.Unlock()
 		}
 	}()
 }
 
-func (f *tailCoordinator) remove(c chan<- time.Time) {
-	f.RLock()
-	initialLen := f.targets.Len()
-	f.RUnlock()
+// add registers a target once the coordinator has started.
+func (f *tailCoordinator) add(c chan<- time.Time) {
+	f.Lock()
+	defer f.Unlock()
+	f.targets = append(f.targets, &tailTarget{trigger: c})
+}
 
-	var visited int
+// hold keeps the scheduler running, even without targets, until release is called:
+// targets can still be added in the meantime.
+func (f *tailCoordinator) hold() {
 	f.Lock()
 	defer f.Unlock()
+	f.holds++
+}
 
-	if f.targets.Len() == 1 {
-		f.targets = ring.New(0)
-		f.log.Println("coordinator: single node buffer: reset", f.targets.Len())
+func (f *tailCoordinator) release() {
+	f.Lock()
+	defer f.Unlock()
+	f.holds--
+}
 
+// done records the outcome of a poll triggered by the coordinator.
+func (f *tailCoordinator) done(c chan<- time.Time, stats cloudwatch.PollStats) {
+	f.Lock()
+	defer f.Unlock()
+
+	_, t := f.find(c)
+	if t == nil {
 		return
 	}
+	if t.inFlight {
+		t.inFlight = false
+		f.inFlight--
+	}
+	t.active = stats.Events > 0
+	t.lastPoll = f.clock.Now()
+	// the trigger paid for the first request: pages, shards and retries are charged now, possibly going into debt
+	if stats.Requests > 1 {
+		f.tokens -= float64(stats.Requests - 1)
+	}
 
-	for visited = 0; visited < f.targets.Len(); visited++ {
-		if f.targets.Value == c {
-			targetChan := f.targets.Value.(chan<- time.Time)
-
-			f.targets = f.targets.Prev()
-			f.targets.Unlink(1)
-			close(targetChan)
-			f.log.Printf("coordinator: channel found and removed at index: %d\n", visited)
-
-			break
+	if stats.Throttled {
+		f.currentRate = f.currentRate / 2
+		if f.currentRate < minRate {
+			f.currentRate = minRate
+		}
+		if f.tokens > 0 {
+			f.tokens = 0
+		}
+		f.log.Printf("coordinator: throttled, backing off to %.2f req/sec\n", f.currentRate)
+	} else if f.currentRate < f.rate {
+		f.currentRate += f.rate / 20
+		if f.currentRate > f.rate {
+			f.currentRate = f.rate
 		}
-		f.targets = f.targets.Next()
 	}
+}
 
-	if f.targets.Len() < initialLen {
-		for i := 0; i < visited; i++ {
-			f.targets = f.targets.Prev()
-		}
+func (f *tailCoordinator) remove(c chan<- time.Time) {
+	f.Lock()
+	defer f.Unlock()
+
+	idx, t := f.find(c)
+	if t == nil {
+		return
+	}
+	if t.inFlight {
+		f.inFlight--
 	}
+	f.targets = append(f.targets[:idx], f.targets[idx+1:]...)
+	close(c)
+	f.log.Printf("coordinator: channel found and removed at index: %d\n", idx)
 }
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+const (
+	exportStateFile    = ".cw-export.json"
+	exportProgressFreq = 2 * time.Second
+	// exportMaxOpenFiles bounds the files open at once, a group can have many more streams than the process can open files
+	exportMaxOpenFiles = 64
+)
+
+type exportCmd struct {
+	LogGroupStreamName []string `arg:"" required:"" name:"groupName[:logStreamPrefix]" help:"The log groups and streams to export, with the same group:streams syntax of tail. Multiple group/stream tuple can be passed."`
+	StartTime          string   `name:"start" help:"The UTC start time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"b" required:""`
+	EndTime            string   `name:"end" help:"The UTC end time. Passed as either date/time or human-friendly format. Defaults to now. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"e" default:""`
+	Local              bool     `name:"local" help:"Treat date and time in Local timezone." short:"l" default:"false"`
+	TZ                 string   `name:"tz" help:"The IANA time zone, e.g. Europe/London, used to interpret --start/--end. Overrides --local." placeholder:"ZONE" default:""`
+	Grep               string   `name:"grep" help:"Pattern to filter logs by. See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax." short:"g" default:""`
+	Out                string   `name:"out" help:"The directory the events are written to, in a file per stream within a directory per group. An interrupted export is resumed by running the same command again." placeholder:"DIR" required:""`
+	Output             string   `name:"output" help:"The format of the files: text or ndjson." short:"o" enum:"text,ndjson" default:"text"`
+	Gzip               bool     `name:"gzip" help:"Compress the files with gzip." short:"z" default:"false"`
+}
+
+// exportState is saved in the export directory so that an interrupted export can be resumed.
+// The checkpoints and the sizes of the files are saved together: on resume the files are truncated
+// to the saved sizes, dropping the events written after the checkpoints, which are exported again.
+type exportState struct {
+	// Args identifies the export, an export with different arguments starts over
+	Args        string           `json:"args"`
+	Start       int64            `json:"start"`
+	End         int64            `json:"end"`
+	Checkpoints *checkpointStore `json:"checkpoints"`
+	Done        map[string]bool  `json:"done"`
+	Files       map[string]int64 `json:"files"`
+}
+
+// loadExportState reads the state of the export in dir. It returns whether the export identified by args is being resumed.
+func loadExportState(dir string, args string, log *log.Logger) (*exportState, bool, error) {
+	fresh := &exportState{
+		Args:        args,
+		Checkpoints: &checkpointStore{log: log, Targets: make(map[string]*cloudwatch.Checkpoint)},
+		Done:        make(map[string]bool),
+		Files:       make(map[string]int64),
+	}
+	b, err := os.ReadFile(filepath.Join(dir, exportStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return fresh, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	state := &exportState{}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, false, fmt.Errorf("invalid export state %s: %w", filepath.Join(dir, exportStateFile), err)
+	}
+	if state.Args != args || state.Checkpoints == nil {
+		return fresh, false, nil
+	}
+	state.Checkpoints.log = log
+	if state.Checkpoints.Targets == nil {
+		state.Checkpoints.Targets = make(map[string]*cloudwatch.Checkpoint)
+	}
+	if state.Done == nil {
+		state.Done = make(map[string]bool)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]int64)
+	}
+	return state, true, nil
+}
+
+type exportFile struct {
+	f      *os.File
+	gz     *gzip.Writer
+	buf    *bufio.Writer
+	writer eventWriter
+	// written is when an event was last written to the file
+	written time.Time
+}
+
+// exporter writes the exported events to a file per stream
+type exporter struct {
+	dir       string
+	output    string
+	gzip      bool
+	formatter logEventFormatter
+	state     *exportState
+	files     map[string]*exportFile
+	lastSave  time.Time
+	// maxOpenFiles is the number of files kept open: past it the least recently written one is closed
+	maxOpenFiles int
+
+	sync.Mutex
+	events int
+	target string
+	last   int64
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// fileName turns a group or stream name into a safe file name, e.g. 2023/01/01/[$LATEST]abc becomes 2023_01_01_LATEST_abc
+func fileName(name string) string {
+	n := strings.Trim(unsafeFileChars.ReplaceAllString(name, "_"), "_")
+	if n == "" || n == "." || n == ".." {
+		return "_"
+	}
+	return n
+}
+
+func (x *exporter) path(group, stream string) string {
+	ext := ".log"
+	if x.output == "ndjson" {
+		ext = ".ndjson"
+	}
+	if x.gzip {
+		ext += ".gz"
+	}
+	return filepath.Join(x.dir, fileName(group), fileName(stream)+ext)
+}
+
+// open opens the file at path, dropping the content written after the last saved checkpoint
+func (x *exporter) open(path string) (*exportFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	size := x.state.Files[path]
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &exportFile{f: f}, nil
+}
+
+func (x *exporter) write(target string, group string, ev types.FilteredLogEvent) error {
+	path := x.path(group, derefString(ev.LogStreamName))
+	file, ok := x.files[path]
+	if !ok {
+		if x.maxOpenFiles > 0 && len(x.files) >= x.maxOpenFiles {
+			if err := x.closeLeastRecentlyWritten(); err != nil {
+				return err
+			}
+		}
+		var err error
+		if file, err = x.open(path); err != nil {
+			return err
+		}
+		x.files[path] = file
+	}
+	if file.writer == nil {
+		// every checkpoint completes the gzip member: a new one is started
+		var w io.Writer = file.f
+		if x.gzip {
+			file.gz = gzip.NewWriter(file.f)
+			w = file.gz
+		}
+		file.buf = bufio.NewWriter(w)
+		file.writer = newEventWriter(file.buf, x.output, x.formatter)
+	}
+	if err := file.writer.write(logEvent{logEvent: ev, logGroup: group, target: target}); err != nil {
+		return err
+	}
+	file.written = time.Now()
+	x.state.Checkpoints.record(target, ev)
+
+	x.Lock()
+	x.events++
+	x.target = target
+	if ev.Timestamp != nil {
+		x.last = *ev.Timestamp
+	}
+	x.Unlock()
+
+	if time.Since(x.lastSave) >= checkpointSaveFreq {
+		return x.checkpoint()
+	}
+	return nil
+}
+
+// closeFile flushes and closes the file, recording its size in the state: open resumes it there.
+// The size is saved with the checkpoints by the next checkpoint, until then a resumed export truncates the file to its previous size.
+func (x *exporter) closeFile(path string, file *exportFile) error {
+	if file.writer != nil {
+		if err := file.buf.Flush(); err != nil {
+			return err
+		}
+		if file.gz != nil {
+			if err := file.gz.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	size, err := file.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	err = file.f.Close()
+	delete(x.files, path)
+	if err != nil {
+		return err
+	}
+	x.state.Files[path] = size
+	return nil
+}
+
+// closeLeastRecentlyWritten closes the file written the longest ago, to open another one under maxOpenFiles
+func (x *exporter) closeLeastRecentlyWritten() error {
+	var lruPath string
+	var lru *exportFile
+	for path, file := range x.files {
+		if lru == nil || file.written.Before(lru.written) {
+			lruPath, lru = path, file
+		}
+	}
+	if lru == nil {
+		return nil
+	}
+	return x.closeFile(lruPath, lru)
+}
+
+// checkpoint closes the files and saves the state of the export
+func (x *exporter) checkpoint() error {
+	for path, file := range x.files {
+		if err := x.closeFile(path, file); err != nil {
+			return err
+		}
+	}
+	b, err := json.MarshalIndent(x.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	x.lastSave = time.Now()
+	return writeFileAtomic(filepath.Join(x.dir, exportStateFile), b)
+}
+
+func (x *exporter) close() error {
+	err := x.checkpoint()
+	// files are left open only if the checkpoint failed
+	for _, file := range x.files {
+		if cerr := file.f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// progress reports the number of events exported and how far into the window the current target is, until ctx is cancelled
+func (x *exporter) progress(ctx context.Context, w io.Writer) {
+	ticker := time.NewTicker(exportProgressFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			x.Lock()
+			events, target, last := x.events, x.target, x.last
+			x.Unlock()
+			if target == "" {
+				continue
+			}
+			pct := 0
+			if window := x.state.End - x.state.Start; window > 0 {
+				pct = int((last - x.state.Start) * 100 / window)
+			}
+			fmt.Fprintf(w, "cw: %d events exported, %s at %s (%d%%)\n", events, target, formatMillis(&last), pct)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *exportCmd) Run(ctx *appContext) error {
+	zone, err := timeZone(e.TZ, e.Local)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %s: %w", e.TZ, err)
+	}
+	st, err := timestampToTimeIn(&e.StartTime, zone)
+	if err != nil {
+		return fmt.Errorf("can't parse %s as a valid date/time", e.StartTime)
+	}
+	et := time.Now()
+	if e.EndTime != "" {
+		if et, err = timestampToTimeIn(&e.EndTime, zone); err != nil {
+			return fmt.Errorf("can't parse %s as a valid date/time", e.EndTime)
+		}
+	}
+
+	type exportTarget struct {
+		groupStream string
+		sel         streamSelector
+	}
+	var targets []exportTarget
+	for _, gs := range e.LogGroupStreamName {
+		sel, err := parseStreamSelector(gs)
+		if err != nil {
+			return err
+		}
+		if !isGroupGlob(sel.group) {
+			targets = append(targets, exportTarget{gs, sel})
+			continue
+		}
+		groups, err := newGroupMatcher(sel.group).refresh(ctx.Context, &ctx.Client)
+		if err != nil {
+			return err
+		}
+		for _, g := range groups {
+			targets = append(targets, exportTarget{g + gs[len(sel.group):], sel.withGroup(g)})
+		}
+	}
+
+	if err := os.MkdirAll(e.Out, 0755); err != nil {
+		return err
+	}
+	args := strings.Join([]string{strings.Join(e.LogGroupStreamName, " "), e.StartTime, e.EndTime, e.TZ, e.Grep, e.Output, fmt.Sprint(e.Local, e.Gzip)}, "|")
+	state, resumed, err := loadExportState(e.Out, args, ctx.DebugLog)
+	if err != nil {
+		return err
+	}
+	if resumed {
+		// relative times would move the window: the one of the interrupted export is kept
+		st, et = millisToTime(state.Start), millisToTime(state.End)
+		fmt.Fprintf(os.Stderr, "cw: resuming the export to %s\n", e.Out)
+	} else {
+		state.Start, state.End = st.UnixNano()/int64(time.Millisecond), et.UnixNano()/int64(time.Millisecond)
+	}
+
+	color.NoColor = true
+	x := &exporter{
+		dir:    e.Out,
+		output: e.Output,
+		gzip:   e.Gzip,
+		formatter: logEventFormatter{
+			FormatConfig: formatConfig{PrintTime: true, TimeFormat: "rfc3339nano", Location: time.UTC},
+			Log:          ctx.DebugLog,
+		},
+		state:        state,
+		files:        make(map[string]*exportFile),
+		lastSave:     time.Now(),
+		maxOpenFiles: exportMaxOpenFiles,
+	}
+	progressCtx, stopProgress := context.WithCancel(ctx.Context)
+	defer stopProgress()
+	go x.progress(progressCtx, os.Stderr)
+
+	follow, retry := false, false
+	for _, target := range targets {
+		if state.Done[target.groupStream] {
+			continue
+		}
+		group, prefix := target.sel.group, target.sel.prefix
+		trigger := make(chan time.Time, 1)
+		trigger <- time.Now()
+		ch, errCh := cloudwatch.Tail(ctx.Context, &ctx.Client, cloudwatch.TailConfig{
+			LogGroupName:     &group,
+			LogStreamName:    &prefix,
+			LogStreamPattern: target.sel.pattern,
+			Follow:           &follow,
+			Retry:            &retry,
+			StartTime:        &st,
+			EndTime:          &et,
+			Grep:             &e.Grep,
+			Grepv:            aws.String(""),
+			Resume:           state.Checkpoints.get(target.groupStream),
+			Warn: func(msg string) {
+				fmt.Fprintln(os.Stderr, "cw: "+msg)
+			},
+		}, trigger, ctx.DebugLog)
+		for ev := range ch {
+			if err := x.write(target.groupStream, group, ev); err != nil {
+				x.close()
+				return err
+			}
+		}
+		if err := <-errCh; err != nil {
+			x.close()
+			return err
+		}
+		if ctx.Context.Err() != nil {
+			if err := x.close(); err != nil {
+				return err
+			}
+			return errors.New("export interrupted, run the same command again to resume it")
+		}
+		state.Done[target.groupStream] = true
+		if err := x.checkpoint(); err != nil {
+			x.close()
+			return err
+		}
+	}
+	if err := x.close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "cw: %d events exported to %d files in %s\n", x.events, len(state.Files), e.Out)
+	return nil
+}
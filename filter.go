@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+)
+
+// fieldMatcher matches a regular expression against a field of a JSON message.
+// The field is selected by a JMESPath expression.
+type fieldMatcher struct {
+	expr  string
+	query *jmespath.JMESPath
+	re    *regexp.Regexp
+}
+
+func (m fieldMatcher) matches(data interface{}) bool {
+	if data == nil {
+		return false
+	}
+	result, err := m.query.Search(data)
+	if err != nil || result == nil {
+		return false
+	}
+	var value string
+	switch v := result.(type) {
+	case string:
+		value = v
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		value = string(b)
+	}
+	return m.re.MatchString(value)
+}
+
+// eventFilter is the client side filtering stage applied to tailed events.
+// An event is dropped if it matches any exclude rule. Otherwise it is kept if it satisfies
+// all the inclusion rules (match and field rules), or any of them when matchAny is set.
+type eventFilter struct {
+	match    []*regexp.Regexp
+	exclude  []*regexp.Regexp
+	fields   []fieldMatcher
+	matchAny bool
+}
+
+func newEventFilter(match []string, exclude []string, fields []string, matchAny bool) (*eventFilter, error) {
+	f := &eventFilter{matchAny: matchAny}
+	for _, m := range match {
+		re, err := regexp.Compile(m)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match pattern %q: %w", m, err)
+		}
+		f.match = append(f.match, re)
+	}
+	for _, e := range exclude {
+		re, err := regexp.Compile(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exclude pattern %q: %w", e, err)
+		}
+		f.exclude = append(f.exclude, re)
+	}
+	for _, field := range fields {
+		tokens := strings.SplitN(field, "=", 2)
+		if len(tokens) != 2 || tokens[0] == "" {
+			return nil, fmt.Errorf("invalid --match-field %q, expected key=regex", field)
+		}
+		query, err := jmespath.Compile(tokens[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match-field key %q: %w", tokens[0], err)
+		}
+		re, err := regexp.Compile(tokens[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid --match-field pattern %q: %w", tokens[1], err)
+		}
+		f.fields = append(f.fields, fieldMatcher{expr: tokens[0], query: query, re: re})
+	}
+	return f, nil
+}
+
+func (f *eventFilter) empty() bool {
+	return len(f.match) == 0 && len(f.exclude) == 0 && len(f.fields) == 0
+}
+
+func (f *eventFilter) matches(msg string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(msg) {
+			return false
+		}
+	}
+	if len(f.match) == 0 && len(f.fields) == 0 {
+		return true
+	}
+
+	var data interface{}
+	if len(f.fields) > 0 {
+		if err := json.Unmarshal([]byte(msg), &data); err != nil {
+			data = nil
+		}
+	}
+
+	for _, re := range f.match {
+		matched := re.MatchString(msg)
+		if f.matchAny && matched {
+			return true
+		}
+		if !f.matchAny && !matched {
+			return false
+		}
+	}
+	for _, m := range f.fields {
+		matched := m.matches(data)
+		if f.matchAny && matched {
+			return true
+		}
+		if !f.matchAny && !matched {
+			return false
+		}
+	}
+	return !f.matchAny
+}
+
+// filterEvents is the pipeline stage between the tailed events and the output: only the events
+// matching the filter are forwarded. The returned channel is closed when in is closed.
+func filterEvents(in <-chan *logEvent, f *eventFilter) <-chan *logEvent {
+	out := make(chan *logEvent)
+	go func() {
+		defer close(out)
+		for ev := range in {
+			if ev.logEvent.Message != nil && f.matches(*ev.logEvent.Message) {
+				out <- ev
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,448 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize  = 500
+	defaultBatchWait  = time.Second
+	defaultQueueSize  = 10000
+	defaultRetries    = 5
+	defaultRetryDelay = 500 * time.Millisecond
+	maxRetryDelay     = 30 * time.Second
+	forwardTimeout    = 10 * time.Second
+)
+
+// batchSender sends a batch of events to a remote system
+type batchSender interface {
+	send(ctx context.Context, batch []logEvent) error
+}
+
+// permanentError is returned by a batchSender when sending the batch again would fail the same way
+type permanentError struct {
+	err error
+}
+
+func (p *permanentError) Error() string { return p.err.Error() }
+func (p *permanentError) Unwrap() error { return p.err }
+
+// batchSink forwards the events to a remote system in batches of up to batchSize events, sent at least every batchWait.
+// Failed batches are retried with exponential backoff. When the remote system can't keep up the queue fills up
+// and write blocks, slowing down the tail.
+// The options are taken from the URI: batch-size, batch-wait, queue and retries, e.g. loki://localhost:3100?batch-size=1000&batch-wait=2s
+type batchSink struct {
+	name       string
+	sender     batchSender
+	batchSize  int
+	batchWait  time.Duration
+	retries    int
+	retryDelay time.Duration
+	log        *log.Logger
+
+	queue    chan logEvent
+	flushReq chan chan error
+	done     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+
+	sync.Mutex
+	err error
+}
+
+// newBatchSink creates the batchSink, removing its options from the query of u
+func newBatchSink(u *url.URL, sender func(u *url.URL) (batchSender, error), log *log.Logger) (*batchSink, error) {
+	b := &batchSink{
+		name:       u.Scheme + "://" + u.Host + u.Path,
+		batchSize:  defaultBatchSize,
+		batchWait:  defaultBatchWait,
+		retries:    defaultRetries,
+		retryDelay: defaultRetryDelay,
+		log:        log,
+		flushReq:   make(chan chan error),
+		done:       make(chan struct{}),
+	}
+	q := u.Query()
+	queueSize := defaultQueueSize
+	for _, opt := range []struct {
+		name  string
+		value *int
+	}{{"batch-size", &b.batchSize}, {"queue", &queueSize}, {"retries", &b.retries}} {
+		if v := q.Get(opt.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %s", opt.name, v)
+			}
+			*opt.value = n
+		}
+		q.Del(opt.name)
+	}
+	if v := q.Get("batch-wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid batch-wait %s", v)
+		}
+		b.batchWait = d
+	}
+	q.Del("batch-wait")
+	u.RawQuery = q.Encode()
+
+	s, err := sender(u)
+	if err != nil {
+		return nil, err
+	}
+	b.sender = s
+	b.queue = make(chan logEvent, queueSize)
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	go b.run()
+	return b, nil
+}
+
+func (b *batchSink) failure() error {
+	b.Lock()
+	defer b.Unlock()
+	return b.err
+}
+
+func (b *batchSink) run() {
+	defer close(b.done)
+	var batch []logEvent
+	var wait <-chan time.Time
+	send := func() {
+		for len(batch) > 0 {
+			n := len(batch)
+			if n > b.batchSize {
+				n = b.batchSize
+			}
+			b.sendWithRetry(batch[:n])
+			batch = batch[n:]
+		}
+		batch, wait = nil, nil
+	}
+	for {
+		select {
+		case ev, ok := <-b.queue:
+			if !ok {
+				send()
+				return
+			}
+			batch = append(batch, ev)
+			if len(batch) == 1 {
+				wait = time.After(b.batchWait)
+			}
+			if len(batch) >= b.batchSize {
+				send()
+			}
+		case <-wait:
+			send()
+		case reply := <-b.flushReq:
+		drain:
+			for {
+				select {
+				case ev, ok := <-b.queue:
+					if !ok {
+						break drain
+					}
+					batch = append(batch, ev)
+				default:
+					break drain
+				}
+			}
+			send()
+			reply <- b.failure()
+		}
+	}
+}
+
+func (b *batchSink) sendWithRetry(batch []logEvent) {
+	if b.failure() != nil {
+		return
+	}
+	for attempt := 0; ; attempt++ {
+		err := b.sender.send(b.ctx, batch)
+		if err == nil {
+			return
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) || attempt+1 >= b.retries {
+			b.Lock()
+			b.err = fmt.Errorf("sink %s: failed to send %d events: %w", b.name, len(batch), err)
+			b.Unlock()
+			return
+		}
+		delay := maxRetryDelay
+		if attempt < 16 && b.retryDelay<<uint(attempt) < delay {
+			delay = b.retryDelay << uint(attempt)
+		}
+		b.log.Printf("sink: %s: %v, retrying in %s\n", b.name, err, delay)
+		select {
+		case <-time.After(delay):
+		case <-b.ctx.Done():
+			return
+		}
+	}
+}
+
+func (b *batchSink) write(ev logEvent) error {
+	if err := b.failure(); err != nil {
+		return err
+	}
+	b.queue <- ev
+	return nil
+}
+
+// flush sends the queued events
+func (b *batchSink) flush() error {
+	reply := make(chan error)
+	select {
+	case b.flushReq <- reply:
+		return <-reply
+	case <-b.done:
+		return b.failure()
+	}
+}
+
+// close sends the queued events and stops the sink
+func (b *batchSink) close() error {
+	select {
+	case <-b.done:
+	default:
+		close(b.queue)
+		<-b.done
+	}
+	b.cancel()
+	if c, ok := b.sender.(io.Closer); ok {
+		c.Close()
+	}
+	return b.failure()
+}
+
+// syslogSender sends the events as RFC5424 syslog messages over UDP or TCP, with octet counting framing.
+// The facility (user or local0-local7) and the app name are taken from the URI, e.g. syslog+tcp://localhost:514?facility=local0&app=orders
+type syslogSender struct {
+	network  string
+	addr     string
+	facility int
+	hostname string
+	app      string
+	conn     net.Conn
+}
+
+var syslogFacilities = map[string]int{
+	"user": 1, "local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities maps the levels found in the messages to syslog severities, informational by default
+var syslogSeverities = map[string]int{
+	"error": 3,
+	"warn":  4,
+	"info":  6,
+	"debug": 7,
+}
+
+func newSyslogSender(u *url.URL) (batchSender, error) {
+	s := &syslogSender{network: "udp", addr: u.Host, facility: syslogFacilities["user"], hostname: "-", app: "cw"}
+	if u.Scheme == "syslog+tcp" {
+		s.network = "tcp"
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		s.addr = net.JoinHostPort(u.Host, "514")
+	}
+	q := u.Query()
+	if v := q.Get("facility"); v != "" {
+		f, ok := syslogFacilities[v]
+		if !ok {
+			return nil, fmt.Errorf("unsupported syslog facility %s, use user or local0-local7", v)
+		}
+		s.facility = f
+	}
+	if v := q.Get("app"); v != "" {
+		s.app = v
+	}
+	if h, err := os.Hostname(); err == nil && h != "" {
+		s.hostname = h
+	}
+	return s, nil
+}
+
+func (s *syslogSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// format returns the RFC5424 message of ev, the group, stream and event id are in the structured data
+func (s *syslogSender) format(ev logEvent) string {
+	msg := strings.TrimRight(derefString(ev.logEvent.Message), "\r\n")
+	severity, ok := syslogSeverities[messageLevel(msg)]
+	if !ok {
+		severity = syslogSeverities["info"]
+	}
+	timestamp := "-"
+	if ev.logEvent.Timestamp != nil {
+		timestamp = formatMillis(ev.logEvent.Timestamp)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - [cw@32473 group=\"%s\" stream=\"%s\" id=\"%s\"] %s",
+		s.facility*8+severity, timestamp, s.hostname, s.app,
+		sdValueEscaper.Replace(ev.logGroup), sdValueEscaper.Replace(derefString(ev.logEvent.LogStreamName)),
+		sdValueEscaper.Replace(derefString(ev.logEvent.EventId)), msg)
+}
+
+func (s *syslogSender) send(ctx context.Context, batch []logEvent) error {
+	if s.conn == nil {
+		d := net.Dialer{Timeout: forwardTimeout}
+		conn, err := d.DialContext(ctx, s.network, s.addr)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	fail := func(err error) error {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	if err := s.conn.SetWriteDeadline(time.Now().Add(forwardTimeout)); err != nil {
+		return fail(err)
+	}
+	if s.network == "udp" {
+		for _, ev := range batch {
+			if _, err := io.WriteString(s.conn, s.format(ev)); err != nil {
+				return fail(err)
+			}
+		}
+		return nil
+	}
+	var buf bytes.Buffer
+	for _, ev := range batch {
+		m := s.format(ev)
+		fmt.Fprintf(&buf, "%d %s", len(m), m)
+	}
+	if _, err := s.conn.Write(buf.Bytes()); err != nil {
+		return fail(err)
+	}
+	return nil
+}
+
+// httpSender posts the batches as ndjson, one structured event per line, as in --output ndjson
+type httpSender struct {
+	url       string
+	client    *http.Client
+	formatter logEventFormatter
+}
+
+// post sends body to url: server errors and throttling can be retried, the other errors are permanent
+func post(ctx context.Context, client *http.Client, url string, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return &permanentError{err}
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	default:
+		return &permanentError{fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))}
+	}
+}
+
+func (h *httpSender) send(ctx context.Context, batch []logEvent) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, ev := range batch {
+		if err := enc.Encode(h.formatter.toRecord(ev)); err != nil {
+			return &permanentError{err}
+		}
+	}
+	return post(ctx, h.client, h.url, "application/x-ndjson", buf.Bytes())
+}
+
+// lokiSender pushes the batches to the Loki push API, with the labels job="cw" and log_group.
+// loki://host:3100 pushes to http://host:3100/loki/api/v1/push, loki+https:// uses https.
+type lokiSender struct {
+	url       string
+	client    *http.Client
+	formatter logEventFormatter
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+func newLokiSender(u *url.URL, formatter logEventFormatter) (batchSender, error) {
+	push := *u
+	push.Scheme = "http"
+	if u.Scheme == "loki+https" {
+		push.Scheme = "https"
+	}
+	if push.Path == "" || push.Path == "/" {
+		push.Path = "/loki/api/v1/push"
+	}
+	return &lokiSender{url: push.String(), client: &http.Client{Timeout: forwardTimeout}, formatter: formatter}, nil
+}
+
+func (l *lokiSender) send(ctx context.Context, batch []logEvent) error {
+	streams := make(map[string]*lokiStream)
+	var groups []string
+	for _, ev := range batch {
+		s, ok := streams[ev.logGroup]
+		if !ok {
+			s = &lokiStream{Stream: map[string]string{"job": "cw", "log_group": ev.logGroup}}
+			streams[ev.logGroup] = s
+			groups = append(groups, ev.logGroup)
+		}
+		var ts int64
+		if ev.logEvent.Timestamp != nil {
+			ts = *ev.logEvent.Timestamp
+		}
+		s.Values = append(s.Values, [2]string{strconv.FormatInt(ts*int64(time.Millisecond), 10), l.formatter.toRecord(ev).rawMessage})
+	}
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{}
+	for _, g := range groups {
+		s := streams[g]
+		// the entries of a stream must be in timestamp order
+		sort.SliceStable(s.Values, func(i, j int) bool {
+			ti, _ := strconv.ParseInt(s.Values[i][0], 10, 64)
+			tj, _ := strconv.ParseInt(s.Values[j][0], 10, 64)
+			return ti < tj
+		})
+		payload.Streams = append(payload.Streams, s)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return &permanentError{err}
+	}
+	return post(ctx, l.client, l.url, "application/json", body)
+}
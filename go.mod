@@ -2,9 +2,11 @@ module github.com/lucagrulla/cw
 
 require (
 	github.com/alecthomas/kong v0.8.0
-	github.com/aws/aws-sdk-go-v2 v1.17.8
+	github.com/aws/aws-sdk-go-v2 v1.24.1
+	github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream v1.5.4
 	github.com/aws/aws-sdk-go-v2/config v1.18.21
-	github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs v1.20.9
+	github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs v1.32.0
+	github.com/aws/smithy-go v1.19.0
 	github.com/fatih/color v1.15.0
 	github.com/jmespath/go-jmespath v0.4.0
 	github.com/stretchr/testify v1.8.4
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+type groupCmd struct {
+	Create    groupCreateCmd    `cmd:"" help:"Create log groups."`
+	Delete    groupDeleteCmd    `cmd:"" help:"Delete log groups and all their events."`
+	Retention groupRetentionCmd `cmd:"" help:"Set how long the events of log groups are kept."`
+	Tag       groupTagCmd       `cmd:"" help:"Add tags to log groups."`
+	Untag     groupUntagCmd     `cmd:"" help:"Remove tags from log groups."`
+}
+
+// groupTargets are the log groups a group command acts on
+type groupTargets struct {
+	Groups []string `arg:"" name:"groupName" optional:"" help:"The log groups. Globs, e.g. /aws/lambda/orders-*, match the existing groups. More groups can be piped on stdin, e.g. cw ls groups | grep dev | cw group retention --days 7."`
+	DryRun bool     `name:"dry-run" help:"Show what would be done without changing anything." default:"false"`
+}
+
+// resolve returns the target groups, with the globs expanded to the matching existing groups
+func (g *groupTargets) resolve(ctx *appContext) ([]string, error) {
+	if additionalInput := fromStdin(); additionalInput != nil {
+		g.Groups = append(g.Groups, additionalInput...)
+	}
+	if len(g.Groups) == 0 {
+		fmt.Fprintln(os.Stderr, "cw: error: required argument 'groupName' not provided, try --help")
+		os.Exit(1)
+	}
+	var groups []string
+	seen := make(map[string]bool)
+	for _, name := range g.Groups {
+		names := []string{name}
+		if isGroupGlob(name) {
+			var err error
+			if names, err = newGroupMatcher(name).refresh(ctx.Context, &ctx.Client); err != nil {
+				return nil, err
+			}
+			if len(names) == 0 {
+				fmt.Fprintf(os.Stderr, "cw: no log group matches %s\n", name)
+			}
+		}
+		for _, n := range names {
+			if !seen[n] {
+				seen[n] = true
+				groups = append(groups, n)
+			}
+		}
+	}
+	return groups, nil
+}
+
+// forEach calls action for every group, carrying on after a failure, and reports done for the groups it succeeded on.
+// With dryRun the action is only described.
+func (g *groupTargets) forEach(ctx context.Context, groups []string, dryRun bool, describe string, done string, action func(group string) error) error {
+	failed := 0
+	for _, group := range groups {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if dryRun {
+			fmt.Printf("%s: would %s (dry run)\n", group, describe)
+			continue
+		}
+		if err := action(group); err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, color.RedString("cw: %s: %v", group, err))
+			continue
+		}
+		fmt.Printf("%s: %s\n", group, done)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d log groups failed", failed, len(groups))
+	}
+	return nil
+}
+
+// confirm asks the question on out and reports whether the answer read from in is yes
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirmOnTerminal asks for confirmation, reading the answer from stdin unless the list of groups was piped on it:
+// the terminal is read instead then
+func confirmOnTerminal(question string) error {
+	var in io.Reader = os.Stdin
+	if stdinConsumed {
+		terminal := "/dev/tty"
+		if runtime.GOOS == "windows" {
+			terminal = "CONIN$"
+		}
+		tty, err := os.Open(terminal)
+		if err != nil {
+			return errors.New("can't ask for confirmation without a terminal, use --yes")
+		}
+		defer tty.Close()
+		in = tty
+	}
+	if !confirm(in, os.Stderr, question) {
+		return errors.New("aborted")
+	}
+	return nil
+}
+
+func groupsQuestion(action string, groups []string) string {
+	if len(groups) == 1 {
+		return fmt.Sprintf("%s log group %s?", action, groups[0])
+	}
+	return fmt.Sprintf("%s %d log groups (%s ... %s)?", action, len(groups), groups[0], groups[len(groups)-1])
+}
+
+// parseTags parses KEY=VALUE tags
+func parseTags(tags []string) (map[string]string, error) {
+	parsed := make(map[string]string, len(tags))
+	for _, t := range tags {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("expected KEY=VALUE, got %s", t)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// parseRetention parses a retention in days, one of the values allowed by CloudWatch, or never
+func parseRetention(days string) (int32, error) {
+	if days == "never" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(days)
+	if err == nil {
+		for _, d := range cloudwatch.RetentionDays {
+			if int32(n) == d {
+				return d, nil
+			}
+		}
+	}
+	allowed := make([]string, len(cloudwatch.RetentionDays))
+	for i, d := range cloudwatch.RetentionDays {
+		allowed[i] = strconv.Itoa(int(d))
+	}
+	return 0, fmt.Errorf("invalid retention %s, expected never or one of %s", days, strings.Join(allowed, ", "))
+}
+
+func describeRetention(days int32) string {
+	if days == 0 {
+		return "never expire"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+func groupsManager(ctx *appContext) *cloudwatch.Groups {
+	return &cloudwatch.Groups{Client: &ctx.Client, Logger: ctx.DebugLog}
+}
+
+type groupCreateCmd struct {
+	groupTargets `embed:""`
+	Retention    string   `name:"retention" help:"The retention in days of the new groups, or never." placeholder:"DAYS" default:"never"`
+	Tags         []string `name:"tag" help:"A tag of the new groups, can be repeated." placeholder:"KEY=VALUE"`
+}
+
+func (c *groupCreateCmd) Run(ctx *appContext) error {
+	days, err := parseRetention(c.Retention)
+	if err != nil {
+		return err
+	}
+	tags, err := parseTags(c.Tags)
+	if err != nil {
+		return err
+	}
+	groups, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	m := groupsManager(ctx)
+	return c.forEach(ctx.Context, groups, c.DryRun, "create", "created", func(group string) error {
+		if err := m.Create(ctx.Context, group, tags); err != nil {
+			return err
+		}
+		if days == 0 {
+			return nil
+		}
+		return m.SetRetention(ctx.Context, group, days)
+	})
+}
+
+type groupDeleteCmd struct {
+	groupTargets `embed:""`
+	Yes          bool `name:"yes" help:"Don't ask for confirmation." short:"y" default:"false"`
+}
+
+func (c *groupDeleteCmd) Run(ctx *appContext) error {
+	groups, err := c.resolve(ctx)
+	if err != nil || len(groups) == 0 {
+		return err
+	}
+	if !c.DryRun && !c.Yes {
+		if err := confirmOnTerminal(groupsQuestion("Delete, with all their events,", groups)); err != nil {
+			return err
+		}
+	}
+	m := groupsManager(ctx)
+	return c.forEach(ctx.Context, groups, c.DryRun, "delete", "deleted", func(group string) error {
+		return m.Delete(ctx.Context, group)
+	})
+}
+
+type groupRetentionCmd struct {
+	groupTargets  `embed:""`
+	Days          string `name:"days" help:"The retention in days, one of the values allowed by CloudWatch e.g. 30, or never." placeholder:"DAYS" required:""`
+	NeverExpiring bool   `name:"never-expiring" help:"Only change the groups whose events never expire." default:"false"`
+	Yes           bool   `name:"yes" help:"Don't ask for confirmation." short:"y" default:"false"`
+}
+
+func (c *groupRetentionCmd) Run(ctx *appContext) error {
+	days, err := parseRetention(c.Days)
+	if err != nil {
+		return err
+	}
+	groups, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	if c.NeverExpiring {
+		groups, err = neverExpiring(ctx, groups)
+		if err != nil {
+			return err
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+	if !c.DryRun && !c.Yes {
+		// a shorter retention deletes the older events
+		question := groupsQuestion(fmt.Sprintf("Set the retention to %s of", describeRetention(days)), groups)
+		if err := confirmOnTerminal(question); err != nil {
+			return err
+		}
+	}
+	m := groupsManager(ctx)
+	return c.forEach(ctx.Context, groups, c.DryRun, "set retention to "+describeRetention(days), "retention set to "+describeRetention(days), func(group string) error {
+		return m.SetRetention(ctx.Context, group, days)
+	})
+}
+
+// neverExpiring returns the groups without a retention policy
+func neverExpiring(ctx *appContext, groups []string) ([]string, error) {
+	all, errCh := cloudwatch.LsGroups(ctx.Context, &ctx.Client, nil, nil)
+	expiring := make(map[string]bool)
+	for g := range all {
+		if g.RetentionInDays != nil {
+			expiring[derefString(g.LogGroupName)] = true
+		}
+	}
+	if err := <-errCh; err != nil {
+		return nil, err
+	}
+	var never []string
+	for _, g := range groups {
+		if !expiring[g] {
+			never = append(never, g)
+		}
+	}
+	return never, nil
+}
+
+type groupTagCmd struct {
+	groupTargets `embed:""`
+	Tags         []string `name:"tag" help:"The tag to add, can be repeated." placeholder:"KEY=VALUE" required:""`
+}
+
+func (c *groupTagCmd) Run(ctx *appContext) error {
+	tags, err := parseTags(c.Tags)
+	if err != nil {
+		return err
+	}
+	groups, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	m := groupsManager(ctx)
+	return c.forEach(ctx.Context, groups, c.DryRun, "tag with "+strings.Join(c.Tags, ", "), "tagged with "+strings.Join(c.Tags, ", "), func(group string) error {
+		return m.Tag(ctx.Context, group, tags)
+	})
+}
+
+type groupUntagCmd struct {
+	groupTargets `embed:""`
+	Keys         []string `name:"key" help:"The key of the tag to remove, can be repeated." placeholder:"KEY" required:""`
+}
+
+func (c *groupUntagCmd) Run(ctx *appContext) error {
+	groups, err := c.resolve(ctx)
+	if err != nil {
+		return err
+	}
+	m := groupsManager(ctx)
+	return c.forEach(ctx.Context, groups, c.DryRun, "untag "+strings.Join(c.Keys, ", "), "untagged "+strings.Join(c.Keys, ", "), func(group string) error {
+		return m.Untag(ctx.Context, group, c.Keys)
+	})
+}
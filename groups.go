@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+// groupMatcher resolves a log group glob, e.g. /aws/lambda/orders-*, to the matching log groups.
+// The listing is narrowed with the literal prefix of the glob, or with its literal part when the glob is *literal*.
+type groupMatcher struct {
+	glob    string
+	re      *regexp.Regexp
+	prefix  string
+	pattern string
+
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func isGroupGlob(group string) bool {
+	return strings.ContainsAny(group, "*?")
+}
+
+func newGroupMatcher(glob string) *groupMatcher {
+	m := &groupMatcher{glob: glob, re: globToRegexp(glob), seen: make(map[string]bool)}
+	m.prefix = glob[:strings.IndexAny(glob, "*?")]
+	if inner := strings.Trim(glob, "*"); m.prefix == "" && inner != "" && !isGroupGlob(inner) {
+		m.pattern = inner
+	}
+	return m
+}
+
+// refresh lists the log groups and returns the matching ones not returned by the previous calls
+func (m *groupMatcher) refresh(ctx context.Context, cwc cloudwatchlogs.DescribeLogGroupsAPIClient) ([]string, error) {
+	groups, errCh := cloudwatch.LsGroups(ctx, cwc, &m.prefix, &m.pattern)
+	var found []string
+	for g := range groups {
+		name := derefString(g.LogGroupName)
+		if m.re.MatchString(name) && m.markSeen(name) {
+			found = append(found, name)
+		}
+	}
+	return found, <-errCh
+}
+
+// markSeen records the group as returned by refresh, reporting false if it already was
+func (m *groupMatcher) markSeen(group string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.seen[group] {
+		return false
+	}
+	m.seen[group] = true
+	return true
+}
+
+// forget lets the next refresh return the group again, e.g. once it has been deleted and can be recreated
+func (m *groupMatcher) forget(group string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.seen, group)
+}
+
+// watch calls refresh every interval, passing the new groups to found, until ctx is cancelled
+func (m *groupMatcher) watch(ctx context.Context, cwc cloudwatchlogs.DescribeLogGroupsAPIClient, interval time.Duration, found func(group string), log *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			groups, err := m.refresh(ctx, cwc)
+			if err != nil {
+				log.Printf("groups: failed to refresh %s: %v\n", m.glob, err)
+				continue
+			}
+			for _, g := range groups {
+				log.Printf("groups: new group %s matches %s\n", g, m.glob)
+				found(g)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
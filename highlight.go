@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+const defaultLevelColors = "error=red,warn=yellow,info=green,debug=magenta"
+
+var colorAttributes = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+// severityLevels maps the level names found in logs to the levels used by the colour scheme
+var severityLevels = map[string]string{
+	"fatal":    "error",
+	"panic":    "error",
+	"critical": "error",
+	"crit":     "error",
+	"error":    "error",
+	"err":      "error",
+	"warning":  "warn",
+	"warn":     "warn",
+	"notice":   "info",
+	"info":     "info",
+	"debug":    "debug",
+	"trace":    "debug",
+}
+
+var (
+	plainLevelRegexp = regexp.MustCompile(`\b(FATAL|PANIC|CRITICAL|CRIT|ERROR|ERR|WARNING|WARN|NOTICE|INFO|DEBUG|TRACE)\b`)
+	jsonLevelRegexp  = regexp.MustCompile(`"level"\s*:\s*"([^"]*)"`)
+)
+
+// messageLevel returns the level, as used by the colour scheme, found in the JSON level field or in the text of msg
+func messageLevel(msg string) string {
+	if m := jsonLevelRegexp.FindStringSubmatch(msg); m != nil {
+		return severityLevels[strings.ToLower(m[1])]
+	}
+	if m := plainLevelRegexp.FindString(msg); m != "" {
+		return severityLevels[strings.ToLower(m)]
+	}
+	return ""
+}
+
+// parseColor parses a colour name, optionally prefixed by "bold-" or "hi-" e.g. bold-red, hi-yellow
+func parseColor(name string) (*color.Color, error) {
+	var attrs []color.Attribute
+	n := strings.ToLower(strings.TrimSpace(name))
+	if strings.HasPrefix(n, "bold-") {
+		attrs = append(attrs, color.Bold)
+		n = strings.TrimPrefix(n, "bold-")
+	}
+	hi := strings.HasPrefix(n, "hi-")
+	n = strings.TrimPrefix(n, "hi-")
+	attr, ok := colorAttributes[n]
+	if !ok {
+		return nil, fmt.Errorf("unknown colour %q", name)
+	}
+	if hi {
+		attr += color.FgHiBlack - color.FgBlack
+	}
+	return color.New(append(attrs, attr)...), nil
+}
+
+// parseLevelColors parses a colour scheme in the level=colour,level=colour format.
+// "none" or an empty scheme disable level colouring.
+func parseLevelColors(scheme string) (map[string]*color.Color, error) {
+	levels := make(map[string]*color.Color)
+	if scheme == "" || scheme == "none" {
+		return levels, nil
+	}
+	for _, item := range strings.Split(scheme, ",") {
+		tokens := strings.SplitN(item, "=", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid level colour %q, expected level=colour", item)
+		}
+		level, ok := severityLevels[strings.ToLower(strings.TrimSpace(tokens[0]))]
+		if !ok {
+			return nil, fmt.Errorf("unknown level %q", tokens[0])
+		}
+		c, err := parseColor(tokens[1])
+		if err != nil {
+			return nil, err
+		}
+		levels[level] = c
+	}
+	return levels, nil
+}
+
+type span struct {
+	start, end int
+	color      *color.Color
+}
+
+// highlighter colours the body of log messages: the substrings matching the highlight
+// patterns, and the severity level found either in plain text or in a JSON level field.
+type highlighter struct {
+	patterns []*regexp.Regexp
+	levels   map[string]*color.Color
+	match    *color.Color
+}
+
+func newHighlighter(patterns []string, levelColors string) (*highlighter, error) {
+	levels, err := parseLevelColors(levelColors)
+	if err != nil {
+		return nil, err
+	}
+	h := &highlighter{levels: levels, match: color.New(color.FgBlack, color.BgYellow)}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --highlight pattern %q: %w", p, err)
+		}
+		h.patterns = append(h.patterns, re)
+	}
+	return h, nil
+}
+
+func (h *highlighter) empty() bool {
+	return len(h.patterns) == 0 && len(h.levels) == 0
+}
+
+func (h *highlighter) levelSpan(msg string) *span {
+	if len(h.levels) == 0 {
+		return nil
+	}
+	if loc := jsonLevelRegexp.FindStringSubmatchIndex(msg); loc != nil {
+		if c, ok := h.levels[severityLevels[strings.ToLower(msg[loc[2]:loc[3]])]]; ok {
+			return &span{start: loc[2], end: loc[3], color: c}
+		}
+		return nil
+	}
+	if loc := plainLevelRegexp.FindStringIndex(msg); loc != nil {
+		if c, ok := h.levels[severityLevels[strings.ToLower(msg[loc[0]:loc[1]])]]; ok {
+			return &span{start: loc[0], end: loc[1], color: c}
+		}
+	}
+	return nil
+}
+
+func (h *highlighter) apply(msg string) string {
+	var spans []span
+	for _, re := range h.patterns {
+		for _, loc := range re.FindAllStringIndex(msg, -1) {
+			if loc[1] > loc[0] {
+				spans = append(spans, span{start: loc[0], end: loc[1], color: h.match})
+			}
+		}
+	}
+	if s := h.levelSpan(msg); s != nil {
+		spans = append(spans, *s)
+	}
+	if len(spans) == 0 {
+		return msg
+	}
+	// overlapping spans are skipped; at the same position highlight patterns win over the level
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	pos := 0
+	for _, s := range spans {
+		if s.start < pos {
+			continue
+		}
+		b.WriteString(msg[pos:s.start])
+		b.WriteString(s.color.Sprint(msg[s.start:s.end]))
+		pos = s.end
+	}
+	b.WriteString(msg[pos:])
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+type insightsCmd struct {
+	LogGroupNames []string `arg:"" optional:"" name:"groupName" help:"The log groups to run the query against. Multiple groups can be passed. e.g. cw insights group1 group2 -q 'stats count(*) by bin(5m)'."`
+	Query         string   `name:"query" help:"The CloudWatch Logs Insights query. See https://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/CWL_QuerySyntax.html for syntax." short:"q" xor:"query"`
+	QueryFile     string   `name:"query-file" help:"Read the CloudWatch Logs Insights query from the given file." type:"existingfile" xor:"query"`
+	StartTime     string   `name:"start" help:"The UTC start time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"b" default:"1h"`
+	EndTime       string   `name:"end" help:"The UTC end time. Passed as either date/time or human-friendly format. Defaults to now. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"e" default:""`
+	Local         bool     `name:"local" help:"Treat date and time in Local timezone." short:"l" default:"false"`
+	TZ            string   `name:"tz" help:"The IANA time zone, e.g. Europe/London, used to interpret --start/--end. Overrides --local." placeholder:"ZONE" default:""`
+	Limit         int32    `name:"limit" help:"The maximum number of rows to return. By default the service limit applies." default:"0"`
+	Output        string   `name:"output" help:"The output format: table, json or csv." short:"o" enum:"table,json,csv" default:"table"`
+}
+
+func (i *insightsCmd) Run(ctx *appContext) error {
+	if additionalInput := fromStdin(); additionalInput != nil {
+		i.LogGroupNames = append(i.LogGroupNames, additionalInput...)
+	}
+	if len(i.LogGroupNames) == 0 {
+		fmt.Fprintln(os.Stderr, "cw: error: required argument 'groupName' not provided, try --help")
+		os.Exit(1)
+	}
+
+	query := i.Query
+	if i.QueryFile != "" {
+		b, err := os.ReadFile(i.QueryFile)
+		if err != nil {
+			return fmt.Errorf("can't read query file %s: %w", i.QueryFile, err)
+		}
+		query = string(b)
+	}
+	if strings.TrimSpace(query) == "" {
+		fmt.Fprintln(os.Stderr, "cw: error: one of --query or --query-file must be provided, try --help")
+		os.Exit(1)
+	}
+
+	zone, err := timeZone(i.TZ, i.Local)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %s: %w", i.TZ, err)
+	}
+	st, err := timestampToTimeIn(&i.StartTime, zone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "can't parse %s as a valid date/time\n", i.StartTime)
+		os.Exit(1)
+	}
+	et := time.Now()
+	if i.EndTime != "" {
+		endT, errr := timestampToTimeIn(&i.EndTime, zone)
+		if errr != nil {
+			fmt.Fprintf(os.Stderr, "can't parse %s as a valid date/time\n", i.EndTime)
+			os.Exit(1)
+		}
+		et = endT
+	}
+
+	rows, err := cloudwatch.Insights(ctx.Context, &ctx.Client, cloudwatch.QueryConfig{
+		LogGroupNames: i.LogGroupNames,
+		Query:         &query,
+		StartTime:     &st,
+		EndTime:       &et,
+		Limit:         &i.Limit,
+	}, ctx.DebugLog)
+	if err != nil {
+		return err
+	}
+
+	return writeQueryResults(os.Stdout, i.Output, rows)
+}
+
+// queryResultColumns returns the field names found in the result rows, in order of first appearance.
+// The @ptr field is an internal reference to the log record and it is never shown.
+func queryResultColumns(rows [][]types.ResultField) []string {
+	var columns []string
+	seen := make(map[string]bool)
+	for _, row := range rows {
+		for _, f := range row {
+			if f.Field == nil || *f.Field == "@ptr" || seen[*f.Field] {
+				continue
+			}
+			seen[*f.Field] = true
+			columns = append(columns, *f.Field)
+		}
+	}
+	return columns
+}
+
+func queryResultRecord(row []types.ResultField) map[string]string {
+	record := make(map[string]string, len(row))
+	for _, f := range row {
+		if f.Field != nil && f.Value != nil {
+			record[*f.Field] = *f.Value
+		}
+	}
+	return record
+}
+
+func writeQueryResults(w io.Writer, format string, rows [][]types.ResultField) error {
+	columns := queryResultColumns(rows)
+
+	switch format {
+	case "json":
+		records := make([]map[string]string, 0, len(rows))
+		for _, row := range rows {
+			record := queryResultRecord(row)
+			delete(record, "@ptr")
+			records = append(records, record)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(columns); err != nil {
+			return err
+		}
+		for _, row := range rows {
+			record := queryResultRecord(row)
+			line := make([]string, len(columns))
+			for idx, c := range columns {
+				line[idx] = record[c]
+			}
+			if err := cw.Write(line); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(columns, "\t"))
+		for _, row := range rows {
+			record := queryResultRecord(row)
+			line := make([]string, len(columns))
+			for idx, c := range columns {
+				line[idx] = strings.ReplaceAll(record[c], "\n", " ")
+			}
+			fmt.Fprintln(tw, strings.Join(line, "\t"))
+		}
+		return tw.Flush()
+	}
+}
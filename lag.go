@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// maxLagSamples bounds the samples kept per group between two summaries; past it reservoir sampling is used.
+const maxLagSamples = 10000
+
+// ingestionLag is the delay between the event timestamp and the time CloudWatch ingested it
+func ingestionLag(ev types.FilteredLogEvent) (time.Duration, bool) {
+	if ev.Timestamp == nil || ev.IngestionTime == nil {
+		return 0, false
+	}
+	return time.Duration(*ev.IngestionTime-*ev.Timestamp) * time.Millisecond, true
+}
+
+func formatLag(lag time.Duration) string {
+	if lag < 0 {
+		return lag.String()
+	}
+	return "+" + lag.String()
+}
+
+type lagSummary struct {
+	group              string
+	count              int
+	p50, p90, p99, max time.Duration
+}
+
+type groupLags struct {
+	samples []time.Duration
+	count   int
+	// max is kept apart from the samples, which may not hold it past maxLagSamples
+	max time.Duration
+}
+
+// lagStats collects the ingestion lag of the tailed events, per group.
+type lagStats struct {
+	sync.Mutex
+	groups map[string]*groupLags
+}
+
+func newLagStats() *lagStats {
+	return &lagStats{groups: make(map[string]*groupLags)}
+}
+
+func (l *lagStats) add(group string, lag time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+	g, ok := l.groups[group]
+	if !ok {
+		g = &groupLags{}
+		l.groups[group] = g
+	}
+	g.count++
+	if g.count == 1 || lag > g.max {
+		g.max = lag
+	}
+	if len(g.samples) < maxLagSamples {
+		g.samples = append(g.samples, lag)
+	} else if i := rand.Intn(g.count); i < maxLagSamples {
+		g.samples[i] = lag
+	}
+}
+
+// percentile returns the nearest-rank percentile p (0-100) of the sorted samples
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// flush returns the summary of the samples collected since the previous flush, sorted by group name
+func (l *lagStats) flush() []lagSummary {
+	l.Lock()
+	groups := l.groups
+	l.groups = make(map[string]*groupLags)
+	l.Unlock()
+
+	var summaries []lagSummary
+	for name, g := range groups {
+		sort.Slice(g.samples, func(i, j int) bool { return g.samples[i] < g.samples[j] })
+		summaries = append(summaries, lagSummary{
+			group: name,
+			count: g.count,
+			p50:   percentile(g.samples, 50),
+			p90:   percentile(g.samples, 90),
+			p99:   percentile(g.samples, 99),
+			max:   g.max,
+		})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].group < summaries[j].group })
+	return summaries
+}
+
+func writeLagSummaries(w io.Writer, summaries []lagSummary) {
+	for _, s := range summaries {
+		fmt.Fprintf(w, "cw: ingestion lag %s: events=%d p50=%s p90=%s p99=%s max=%s\n",
+			s.group, s.count, s.p50, s.p90, s.p99, s.max)
+	}
+}
+
+// run writes a summary of the collected lags to w every interval, until ctx is cancelled
+func (l *lagStats) run(ctx context.Context, interval time.Duration, w io.Writer) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			writeLagSummaries(w, l.flush())
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+)
+
+// formatBytes returns a human readable size, e.g. 1.5 MiB
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func formatMillisTime(ms *int64) string {
+	if ms == nil {
+		return ""
+	}
+	return millisToTime(*ms).UTC().Format(time.RFC3339)
+}
+
+func int64Value(v *int64) int64 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func int32Value(v *int32) int32 {
+	if v == nil {
+		return 0
+	}
+	return *v
+}
+
+func sortGroups(groups []types.LogGroup, by string) {
+	switch by {
+	case "size":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return int64Value(groups[i].StoredBytes) > int64Value(groups[j].StoredBytes)
+		})
+	case "age":
+		sort.SliceStable(groups, func(i, j int) bool {
+			return int64Value(groups[i].CreationTime) < int64Value(groups[j].CreationTime)
+		})
+	default:
+		sort.SliceStable(groups, func(i, j int) bool {
+			return derefString(groups[i].LogGroupName) < derefString(groups[j].LogGroupName)
+		})
+	}
+}
+
+type groupRecord struct {
+	Name              string `json:"name"`
+	Arn               string `json:"arn,omitempty"`
+	StoredBytes       int64  `json:"storedBytes"`
+	RetentionInDays   *int32 `json:"retentionInDays"`
+	CreationTime      string `json:"creationTime"`
+	MetricFilterCount int32  `json:"metricFilterCount"`
+	KmsKeyID          string `json:"kmsKeyId,omitempty"`
+}
+
+func toGroupRecord(g types.LogGroup) groupRecord {
+	return groupRecord{
+		Name:              derefString(g.LogGroupName),
+		Arn:               derefString(g.Arn),
+		StoredBytes:       int64Value(g.StoredBytes),
+		RetentionInDays:   g.RetentionInDays,
+		CreationTime:      formatMillisTime(g.CreationTime),
+		MetricFilterCount: int32Value(g.MetricFilterCount),
+		KmsKeyID:          derefString(g.KmsKeyId),
+	}
+}
+
+func retention(days *int32) string {
+	if days == nil {
+		return "never expire"
+	}
+	return fmt.Sprintf("%d days", *days)
+}
+
+func writeGroups(w io.Writer, format string, long bool, groups []types.LogGroup) error {
+	switch format {
+	case "json":
+		records := make([]groupRecord, 0, len(groups))
+		for _, g := range groups {
+			records = append(records, toGroupRecord(g))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"name", "stored_bytes", "retention_days", "creation_time", "metric_filter_count", "kms_key_id"}); err != nil {
+			return err
+		}
+		for _, g := range groups {
+			r := toGroupRecord(g)
+			var days string
+			if r.RetentionInDays != nil {
+				days = strconv.Itoa(int(*r.RetentionInDays))
+			}
+			if err := cw.Write([]string{r.Name, strconv.FormatInt(r.StoredBytes, 10), days, r.CreationTime,
+				strconv.Itoa(int(r.MetricFilterCount)), r.KmsKeyID}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		if !long {
+			for _, g := range groups {
+				fmt.Fprintln(w, derefString(g.LogGroupName))
+			}
+			return nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSTORED\tRETENTION\tCREATED\tMETRIC FILTERS\tKMS KEY")
+		for _, g := range groups {
+			r := toGroupRecord(g)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", r.Name, formatBytes(r.StoredBytes), retention(r.RetentionInDays),
+				r.CreationTime, r.MetricFilterCount, r.KmsKeyID)
+		}
+		return tw.Flush()
+	}
+}
+
+// lastEvent is the time of the last event of the stream, falling back to its last ingestion time
+func lastEvent(s types.LogStream) int64 {
+	if s.LastEventTimestamp != nil {
+		return *s.LastEventTimestamp
+	}
+	return int64Value(s.LastIngestionTime)
+}
+
+// selectStreams keeps the streams with events since activeSince (when not zero),
+// sorts them and returns the first limit ones (all of them when limit is 0)
+func selectStreams(streams []types.LogStream, activeSince time.Time, by string, limit int) []types.LogStream {
+	var selected []types.LogStream
+	for _, s := range streams {
+		if !activeSince.IsZero() && lastEvent(s) < activeSince.UnixMilli() {
+			continue
+		}
+		selected = append(selected, s)
+	}
+	switch by {
+	case "last-event":
+		sort.SliceStable(selected, func(i, j int) bool {
+			return lastEvent(selected[i]) > lastEvent(selected[j])
+		})
+	default:
+		sort.SliceStable(selected, func(i, j int) bool {
+			return derefString(selected[i].LogStreamName) < derefString(selected[j].LogStreamName)
+		})
+	}
+	if limit > 0 && len(selected) > limit {
+		selected = selected[:limit]
+	}
+	return selected
+}
+
+type streamRecord struct {
+	Name                string `json:"name"`
+	Arn                 string `json:"arn,omitempty"`
+	CreationTime        string `json:"creationTime"`
+	FirstEventTimestamp string `json:"firstEventTimestamp"`
+	LastEventTimestamp  string `json:"lastEventTimestamp"`
+	LastIngestionTime   string `json:"lastIngestionTime"`
+}
+
+func toStreamRecord(s types.LogStream) streamRecord {
+	return streamRecord{
+		Name:                derefString(s.LogStreamName),
+		Arn:                 derefString(s.Arn),
+		CreationTime:        formatMillisTime(s.CreationTime),
+		FirstEventTimestamp: formatMillisTime(s.FirstEventTimestamp),
+		LastEventTimestamp:  formatMillisTime(s.LastEventTimestamp),
+		LastIngestionTime:   formatMillisTime(s.LastIngestionTime),
+	}
+}
+
+func writeStreams(w io.Writer, format string, long bool, streams []types.LogStream) error {
+	switch format {
+	case "json":
+		records := make([]streamRecord, 0, len(streams))
+		for _, s := range streams {
+			records = append(records, toStreamRecord(s))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	default:
+		if !long {
+			for _, s := range streams {
+				fmt.Fprintln(w, derefString(s.LogStreamName))
+			}
+			return nil
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tCREATED\tLAST EVENT\tLAST INGESTION")
+		for _, s := range streams {
+			r := toStreamRecord(s)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, r.CreationTime, r.LastEventTimestamp, r.LastIngestionTime)
+		}
+		return tw.Flush()
+	}
+}
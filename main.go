@@ -2,16 +2,20 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"text/template"
 	"time"
 	"unicode"
 
@@ -38,6 +42,25 @@ func timestampToTime(timeStamp *string, local bool) (time.Time, error) {
 	} else {
 		zone = time.UTC
 	}
+	return timestampToTimeIn(timeStamp, zone)
+}
+
+// timeZone returns the zone to interpret and print dates in: the IANA zone tz if given,
+// otherwise Local or UTC depending on the local flag.
+func timeZone(tz string, local bool) (*time.Location, error) {
+	if tz != "" {
+		return time.LoadLocation(tz)
+	}
+	if local {
+		return time.Local, nil
+	}
+	return time.UTC, nil
+}
+
+func timestampToTimeIn(timeStamp *string, zone *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, *timeStamp); err == nil {
+		return t, nil
+	}
 	if regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`).MatchString(*timeStamp) {
 		t, _ := time.ParseInLocation("2006-01-02", *timeStamp, zone)
 		return t, nil
@@ -52,7 +75,7 @@ func timestampToTime(timeStamp *string, local bool) (time.Time, error) {
 		t, _ := strconv.Atoi(*timeStamp)
 		return time.Date(y, m, d, t, 0, 0, 0, zone), nil
 	} else if res := regexp.MustCompile(`^(?P<Hour>\d{1,2}):(?P<Minute>\d{2})$`).FindStringSubmatch(*timeStamp); res != nil {
-		y, m, d := time.Now().Date()
+		y, m, d := time.Now().In(zone).Date()
 
 		t, _ := strconv.Atoi(res[1])
 		mm, _ := strconv.Atoi(res[2])
@@ -82,6 +105,8 @@ type logEvent struct {
 	// logEvent cloudwatchlogs.FilteredLogEvent
 	logEvent types.FilteredLogEvent
 	logGroup string
+	// target is the groupName[:logStreamPrefix] argument the event has been tailed for
+	target string
 }
 
 type formatConfig struct {
@@ -89,7 +114,14 @@ type formatConfig struct {
 	PrintStreamName bool
 	PrintGroupName  bool
 	PrintEventID    bool
+	PrintLag        bool
 	Query           *jmespath.JMESPath
+	Highlighter     *highlighter
+	Template        *template.Template
+	// TimeFormat is one of default, rfc3339nano, epochms, relative or a Go time layout
+	TimeFormat string
+	// Location is the zone timestamps are printed in, Local when nil
+	Location *time.Location
 }
 
 type logEventFormatter struct {
@@ -129,6 +161,14 @@ func (f logEventFormatter) formatLogMsg(ev logEvent) string {
 		msg = f.jmespathQuery(msg, *f.FormatConfig.Query)
 	}
 
+	if f.FormatConfig.Template != nil {
+		return f.executeTemplate(ev, msg)
+	}
+
+	if f.FormatConfig.Highlighter != nil {
+		msg = f.FormatConfig.Highlighter.apply(msg)
+	}
+
 	if f.FormatConfig.PrintEventID {
 		msg = fmt.Sprintf("%s - %s", color.YellowString(*ev.logEvent.EventId), msg)
 	}
@@ -140,18 +180,64 @@ func (f logEventFormatter) formatLogMsg(ev logEvent) string {
 		msg = fmt.Sprintf("%s - %s", color.CyanString(ev.logGroup), msg)
 	}
 
+	if f.FormatConfig.PrintLag {
+		if lag, ok := ingestionLag(ev.logEvent); ok {
+			msg = fmt.Sprintf("%s - %s", color.MagentaString(formatLag(lag)), msg)
+		}
+	}
+
 	if f.FormatConfig.PrintTime {
-		eventTimestamp := *ev.logEvent.Timestamp / 1000
-		ts := time.Unix(eventTimestamp, 0).Format(timeFormat)
+		ts := f.formatTimestamp(*ev.logEvent.Timestamp)
 		msg = fmt.Sprintf("%s - %s", color.GreenString(ts), msg)
 	}
 	return msg
 }
 
+// relativeTime describes t relative to now, e.g. "3s ago"
+func relativeTime(now time.Time, t time.Time) string {
+	d := now.Sub(t)
+	future := d < 0
+	if future {
+		d = -d
+	}
+	if d < time.Second {
+		d = d.Round(time.Millisecond)
+	} else {
+		d = d.Round(time.Second)
+	}
+	if future {
+		return "in " + d.String()
+	}
+	return d.String() + " ago"
+}
+
+func (f logEventFormatter) formatTimestamp(millis int64) string {
+	t := millisToTime(millis)
+	if f.FormatConfig.Location != nil {
+		t = t.In(f.FormatConfig.Location)
+	}
+	switch f.FormatConfig.TimeFormat {
+	case "", "default":
+		return t.Format(timeFormat)
+	case "rfc3339nano":
+		return t.Format(time.RFC3339Nano)
+	case "epochms":
+		return strconv.FormatInt(millis, 10)
+	case "relative":
+		return relativeTime(time.Now(), t)
+	default:
+		return t.Format(f.FormatConfig.TimeFormat)
+	}
+}
+
+// stdinConsumed is set once fromStdin has read stdin: it can't be read for anything else, e.g. a confirmation
+var stdinConsumed bool
+
 func fromStdin() []string {
 	var groups []string
 	info, _ := os.Stdin.Stat()
 	if info.Mode()&os.ModeNamedPipe != 0 {
+		stdinConsumed = true
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
 			input := scanner.Text()
@@ -167,31 +253,135 @@ func fromStdin() []string {
 }
 
 type appContext struct {
+	Context  context.Context
 	Debug    bool
 	Client   cloudwatchlogs.Client
 	DebugLog *log.Logger
 }
 
 type lsGroupsCmd struct {
+	Prefix  string `name:"prefix" help:"Only show the groups whose name starts with the given prefix." xor:"filter"`
+	Pattern string `name:"pattern" help:"Only show the groups whose name contains the given string (case insensitive)." xor:"filter"`
+	Long    bool   `name:"long" help:"Show stored bytes, retention, creation time, metric filters count and KMS key of every group." short:"l" default:"false"`
+	Output  string `name:"output" help:"The output format: text, json or csv." short:"o" enum:"text,json,csv" default:"text"`
+	Sort    string `name:"sort" help:"Sort groups by name, size (largest first) or age (oldest first)." enum:"name,size,age" default:"name"`
 }
 type lsStreamsCmd struct {
-	GroupName string `arg required name:"group" help:"The group name."`
+	GroupName   string `arg required name:"group" help:"The group name."`
+	Long        bool   `name:"long" help:"Show creation, last event and last ingestion time of every stream." short:"l" default:"false"`
+	Sort        string `name:"sort" help:"Sort streams by name or last-event (most recent first)." enum:"name,last-event" default:"name"`
+	ActiveSince string `name:"active-since" help:"Only show the streams with events since the given time. Accepts the same formats as tail --start, e.g. 2h, 2017-02-27T09:00." default:""`
+	Limit       int    `name:"limit" help:"Show at most the given number of streams, 0 for no limit." default:"0"`
+	Output      string `name:"output" help:"The output format: text or json." short:"o" enum:"text,json" default:"text"`
 }
 
 type tailCmd struct {
-	LogGroupStreamName []string `arg required name:"groupName[:logStreamPrefix]" help:"The log group and stream name, with group:prefix syntax. Stream name can be just the prefix. If no stream name is specified all stream names in the given group will be tailed. Multiple group/stream tuple can be passed. e.g. cw tail group1:prefix1 group2:prefix2 group3:prefix3."`
-	Follow             bool     `help:"Don't stop when the end of streams is reached, but rather wait for additional data to be appended." default:"false" short:"f"`
-	PrintTimeStamp     bool     `name:"timestamp" help:"Print the event timestamp." short:"t" default:"false"`
-	PrintEventID       bool     `name:"event-id" help:"Print the event Id." short:"i" default:"false"`
-	PrintStreamName    bool     `name:"stream-name" help:"Print the log stream name this event belongs to." short:"s" default:"false"`
-	PrintGroupName     bool     `name:"group-name" help:"Print the log group name this event belongs to." short:"n" default:"false"`
-	Retry              bool     `name:"retry" help:"Keep trying to open a log group/log stream if it is inaccessible." short:"r" default:"false"`
-	StartTime          string   `name:"start" help:"The UTC start time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"b" default:"${now}"`
-	EndTime            string   `name:"end" help:"The UTC end time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"e" default:""`
-	Local              bool     `name:"local" help:"Treat date and time in Local timezone." short:"l" default:"false"`
-	Grep               string   `name:"grep" help:"Pattern to filter logs by. See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax." short:"g" default:""`
-	Grepv              string   `name:"grepv" help:"Equivalent of grep --invert-match. Invert match pattern to filter logs by." short:"v" default:""`
-	Query              string   `name:"query" help:"Equivalent of the --query flag in AWS CLI. Takes a JMESPath expression to filter JSON logs by." short:"q" default:""`
+	LogGroupStreamName []string      `arg required name:"groupName[:logStreamPrefix]" help:"The log group and stream name, with group:prefix syntax. Stream name can be just the prefix, a glob (e.g. group:*/api/*) or a regular expression prefixed by ~ (e.g. group:~^web-.*-blue$). If no stream name is specified all stream names in the given group will be tailed. The group name can be a glob, e.g. '/aws/lambda/orders-*', to tail all the matching groups. Multiple group/stream tuple can be passed. e.g. cw tail group1:prefix1 group2:prefix2 group3:prefix3."`
+	Follow             bool          `help:"Don't stop when the end of streams is reached, but rather wait for additional data to be appended." default:"false" short:"f"`
+	PrintTimeStamp     bool          `name:"timestamp" help:"Print the event timestamp." short:"t" default:"false"`
+	PrintEventID       bool          `name:"event-id" help:"Print the event Id." short:"i" default:"false"`
+	PrintStreamName    bool          `name:"stream-name" help:"Print the log stream name this event belongs to." short:"s" default:"false"`
+	PrintGroupName     bool          `name:"group-name" help:"Print the log group name this event belongs to." short:"n" default:"false"`
+	Retry              bool          `name:"retry" help:"Keep trying to open a log group/log stream if it is inaccessible." short:"r" default:"false"`
+	StartTime          string        `name:"start" help:"The UTC start time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"b" default:"${now}"`
+	EndTime            string        `name:"end" help:"The UTC end time. Passed as either date/time or human-friendly format. The human-friendly format accepts the number of days, hours and minutes prior to the present. Denote days with 'd', hours with 'h' and minutes with 'm' i.e. 80m, 4h30m, 2d4h. If just time is used (format: hh[:mm]) it is expanded to today at the given time. Full available date/time format: 2017-02-27[T09[:00[:00]]." short:"e" default:""`
+	Local              bool          `name:"local" help:"Treat date and time in Local timezone." short:"l" default:"false"`
+	Grep               string        `name:"grep" help:"Pattern to filter logs by. See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax." short:"g" default:""`
+	Grepv              string        `name:"grepv" help:"Equivalent of grep --invert-match. Invert match pattern to filter logs by." short:"v" default:""`
+	Query              string        `name:"query" help:"Equivalent of the --query flag in AWS CLI. Takes a JMESPath expression to filter JSON logs by." short:"q" default:""`
+	Match              []string      `name:"match" help:"Only show events matching the given regular expression. Can be repeated." placeholder:"REGEX" sep:"none"`
+	Exclude            []string      `name:"exclude" help:"Hide events matching the given regular expression. Can be repeated." placeholder:"REGEX" sep:"none"`
+	MatchField         []string      `name:"match-field" help:"Only show JSON events where the field selected by the JMESPath key matches the regular expression. Can be repeated." placeholder:"KEY=REGEX" sep:"none"`
+	MatchAny           bool          `name:"match-any" help:"Show events satisfying any of the --match/--match-field rules rather than all of them." default:"false"`
+	Highlight          []string      `name:"highlight" help:"Colour the parts of the messages matching the given regular expression. Can be repeated." placeholder:"REGEX" sep:"none"`
+	LevelColors        string        `name:"level-colors" help:"Colour scheme for the log levels (error, warn, info, debug) found in messages, either in plain text or in a JSON level field. Colours: black, red, green, yellow, blue, magenta, cyan, white, optionally prefixed by bold- or hi-. Use none to disable." placeholder:"LEVEL=COLOUR,..." default:"${levelColors}"`
+	PrintLag           bool          `name:"lag" help:"Print the ingestion lag of the event: the delay between the event timestamp and the time CloudWatch ingested it." default:"false"`
+	LagSummary         time.Duration `name:"lag-summary" help:"Print to stderr a summary of the ingestion lag percentiles per group at the given interval, e.g. 30s." placeholder:"INTERVAL" default:"0s"`
+	TimeFormat         string        `name:"time-format" help:"The format of the event timestamp: default (2006-01-02T15:04:05), rfc3339nano, epochms, relative (e.g. 3s ago) or a custom Go time layout e.g. 15:04:05.000." default:"default"`
+	TZ                 string        `name:"tz" help:"The IANA time zone, e.g. Europe/London, used to interpret --start/--end and to print timestamps. Overrides --local." placeholder:"ZONE" default:""`
+	Format             string        `name:"format" help:"Go template used to print every event in text output, e.g. '{{.Timestamp | fmtTime \"15:04:05.000\"}} {{.Group | cyan}} {{.JSON.level}} {{.Message}}'. Fields: Timestamp, IngestionTime, Lag, Group, Stream, ID, Message, JSON. JSON holds the top level fields of a JSON message as text, a missing field is an empty string. Functions: color, red, green, yellow, blue, magenta, cyan, white, black, fmtTime, truncate, upper, lower, json, default, highlight. Overrides the --timestamp, --event-id, --stream-name and --group-name flags." placeholder:"TEMPLATE" default:""`
+	Live               bool          `name:"live" help:"Stream the new events with CloudWatch Logs Live Tail rather than polling. Implies --follow, --start is ignored. Groups for which Live Tail is not available are polled." default:"false"`
+	Ordered            bool          `name:"ordered" help:"Print the events of all the tailed groups in timestamp order. Every event is held for --order-delay to give the older events of the other groups time to arrive." default:"false"`
+	OrderDelay         time.Duration `name:"order-delay" help:"How long events are held by --ordered. Events arriving later than that are printed out of order." default:"2s"`
+	Rate               float64       `name:"rate" help:"The maximum number of requests per second shared across all the tailed groups. The rate is automatically reduced when AWS throttles the requests." default:"5"`
+	MaxConcurrency     int           `name:"max-concurrency" help:"The maximum number of groups polled at the same time." default:"4"`
+	GroupRefresh       time.Duration `name:"group-refresh" help:"How often the log groups matching a glob in the group name, e.g. '/aws/lambda/orders-*', are listed again to follow the new ones." default:"30s"`
+	MaxStreams         int           `name:"max-streams" help:"The maximum number of streams of a group tailed by name, polled 100 at a time. Past it the streams are selected by prefix." default:"500"`
+	Output             string        `name:"output" help:"The output format: text, json (an array of all the events, complete once tailing stops), ndjson (a JSON object per line), logfmt or csv. Structured formats include timestamp, ingestion time, group, stream, event id and message of every event." short:"o" enum:"text,json,ndjson,logfmt,csv" default:"text"`
+	Sink               string        `name:"sink" help:"Write the events to the given destination rather than stdout, in the --output format. file://DIR writes a file per stream within a directory per group, rotated by size and age and optionally compressed, e.g. file://./logs?max-size=100MB&max-age=1h&compress=gzip. The checkpoint is kept in DIR unless --checkpoint is set. syslog+tcp://HOST:PORT and syslog+udp://HOST:PORT forward RFC5424 messages, http(s)://URL posts ndjson batches and loki(+https)://HOST:PORT pushes to Loki. Remote sinks batch the events, e.g. ?batch-size=500&batch-wait=1s, and retry failed batches, e.g. ?retries=5." placeholder:"URI" default:""`
+	Checkpoint         string        `name:"checkpoint" help:"Save the position reached for every group/stream to the given file and, when the file exists, resume from it rather than from --start. Events are never printed twice across restarts." placeholder:"FILE" default:""`
+}
+
+// streamSelector is the parsed form of a group[:streams] tail argument.
+// Streams are selected by literal prefix, by glob (e.g. */api/*) or by regular expression when prefixed by ~ (e.g. ~^web-.*-blue$).
+type streamSelector struct {
+	group   string
+	prefix  string
+	pattern *regexp.Regexp
+}
+
+// globToRegexp converts a glob where * matches any sequence of characters and ? any single character
+func globToRegexp(glob string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// withGroup returns the selector applied to the given group
+func (s streamSelector) withGroup(group string) streamSelector {
+	s.group = group
+	return s
+}
+
+func parseStreamSelector(groupStream string) (streamSelector, error) {
+	tokens := strings.SplitN(groupStream, ":", 2)
+	sel := streamSelector{group: tokens[0]}
+	if len(tokens) < 2 || tokens[1] == "" || tokens[1] == "*" {
+		return sel, nil
+	}
+	streams := tokens[1]
+	switch {
+	case strings.HasPrefix(streams, "~"):
+		re, err := regexp.Compile(streams[1:])
+		if err != nil {
+			return sel, fmt.Errorf("invalid stream regex in %q: %w", groupStream, err)
+		}
+		sel.pattern = re
+		// an anchored regex narrows the streams listing to its literal prefix
+		if strings.HasPrefix(streams, "~^") {
+			sel.prefix, _ = re.LiteralPrefix()
+		}
+	case strings.ContainsAny(streams, "*?"):
+		sel.pattern = globToRegexp(streams)
+		sel.prefix = streams[:strings.IndexAny(streams, "*?")]
+	default:
+		sel.prefix = streams
+	}
+	return sel, nil
+}
+
+// formatConfig prints the timestamps in zone, the one --start and --end are parsed in
+func (t *tailCmd) formatConfig(zone *time.Location) formatConfig {
+	return formatConfig{
+		PrintTime:       t.PrintTimeStamp,
+		PrintStreamName: t.PrintStreamName,
+		PrintGroupName:  t.PrintGroupName,
+		PrintEventID:    t.PrintEventID,
+		PrintLag:        t.PrintLag,
+		TimeFormat:      t.TimeFormat,
+		Location:        zone,
+	}
 }
 
 func (t *tailCmd) Run(ctx *appContext) error {
@@ -203,14 +393,24 @@ func (t *tailCmd) Run(ctx *appContext) error {
 		os.Exit(1)
 	}
 
-	st, err := timestampToTime(&t.StartTime, t.Local)
+	if t.Live {
+		t.Follow = true
+	}
+	if t.Sink != "" {
+		color.NoColor = true
+	}
+	zone, err := timeZone(t.TZ, t.Local)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %s: %w", t.TZ, err)
+	}
+	st, err := timestampToTimeIn(&t.StartTime, zone)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "can't parse %s as a valid date/time\n", t.StartTime)
 		os.Exit(1)
 	}
 	var et time.Time
 	if t.EndTime != "" {
-		endT, errr := timestampToTime(&t.EndTime, t.Local)
+		endT, errr := timestampToTimeIn(&t.EndTime, zone)
 		if errr != nil {
 			fmt.Fprintf(os.Stderr, "can't parse %s as a valid date/time\n", t.EndTime)
 			os.Exit(1)
@@ -218,47 +418,199 @@ func (t *tailCmd) Run(ctx *appContext) error {
 			et = endT
 		}
 	}
+	filter, err := newEventFilter(t.Match, t.Exclude, t.MatchField, t.MatchAny)
+	if err != nil {
+		return err
+	}
+	if _, err := regexp.Compile(t.Grepv); err != nil {
+		return fmt.Errorf("invalid --grepv pattern %q: %w", t.Grepv, err)
+	}
+
+	config := t.formatConfig(zone)
+	highlighter, err := newHighlighter(t.Highlight, t.LevelColors)
+	if err != nil {
+		return err
+	}
+	if !highlighter.empty() {
+		config.Highlighter = highlighter
+	}
+	if t.Format != "" {
+		tmpl, err := newEventTemplate(t.Format, config.Highlighter)
+		if err != nil {
+			return err
+		}
+		config.Template = tmpl
+	}
+	if t.Query != "" {
+		query, err := jmespath.Compile(t.Query)
+		if err != nil {
+			return fmt.Errorf("failed to parse query as JMESPath query. Query: \"%s\", error: \"%w\"", t.Query, err)
+		}
+		config.Query = query
+	}
+
+	formatter := logEventFormatter{
+		FormatConfig: config,
+		Log:          ctx.DebugLog}
+
+	var dest sink = &writerSink{writer: newEventWriter(os.Stdout, t.Output, formatter)}
+	if t.Sink != "" {
+		if dest, err = newSink(t.Sink, t.Output, formatter, ctx.DebugLog); err != nil {
+			return err
+		}
+		// the file sink keeps its checkpoint with the files
+		if fs, ok := dest.(*fileSink); ok && t.Checkpoint == "" {
+			t.Checkpoint = fs.checkpointPath()
+		}
+	}
+
+	var checkpoints *checkpointStore
+	if t.Checkpoint != "" {
+		checkpoints, err = loadCheckpoints(t.Checkpoint, ctx.DebugLog)
+		if err != nil {
+			return fmt.Errorf("can't load checkpoint file %s: %w", t.Checkpoint, err)
+		}
+		checkpoints.flush = dest.flush
+		go checkpoints.run(ctx.Context, checkpointSaveFreq, os.Stderr)
+	}
+
 	out := make(chan *logEvent)
 
 	var wg sync.WaitGroup
 
-	triggerChannels := make([]chan<- time.Time, len(t.LogGroupStreamName))
-
-	coordinator := &tailCoordinator{log: ctx.DebugLog}
+	coordinator := &tailCoordinator{log: ctx.DebugLog, rate: t.Rate, maxConcurrency: t.MaxConcurrency}
+	selectors := make([]streamSelector, len(t.LogGroupStreamName))
 	for idx, gs := range t.LogGroupStreamName {
+		sel, err := parseStreamSelector(gs)
+		if err != nil {
+			return err
+		}
+		selectors[idx] = sel
+	}
+
+	// startTail tails a single group. Groups found by a glob, matcher is nil otherwise, can be deleted while tailed:
+	// that is not fatal and the group is tailed again if it is recreated.
+	// In live mode the group is polled only if Live Tail is not available.
+	startTail := func(groupStream string, sel streamSelector, matcher *groupMatcher) {
 		trigger := make(chan time.Time, 1)
-		go func(groupStream string) {
-			tokens := strings.Split(groupStream, ":")
-			var prefix string
-			group := tokens[0]
-			if len(tokens) > 1 && tokens[1] != "*" {
-				prefix = tokens[1]
+		if t.Live {
+			// keep the coordinator running for the fallback to polling
+			coordinator.hold()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			group, prefix := sel.group, sel.prefix
+			var resume *cloudwatch.Checkpoint
+			if checkpoints != nil {
+				resume = checkpoints.get(groupStream)
 			}
-			ch, e := cloudwatch.Tail(&ctx.Client, cloudwatch.TailConfig{
-				LogGroupName:  &group,
-				LogStreamName: &prefix,
-				Follow:        &t.Follow,
-				Retry:         &t.Retry,
-				StartTime:     &st,
-				EndTime:       &et,
-				Grep:          &t.Grep,
-				Grepv:         &t.Grepv,
-			}, trigger, ctx.DebugLog)
-			if e != nil {
-				fmt.Fprintln(os.Stderr, e.Error())
-				os.Exit(1)
+			config := cloudwatch.TailConfig{
+				LogGroupName:     &group,
+				LogStreamName:    &prefix,
+				LogStreamPattern: sel.pattern,
+				Follow:           &t.Follow,
+				Retry:            &t.Retry,
+				StartTime:        &st,
+				EndTime:          &et,
+				Grep:             &t.Grep,
+				Grepv:            &t.Grepv,
+				OnPoll: func(stats cloudwatch.PollStats) {
+					coordinator.done(trigger, stats)
+				},
+				Resume:     resume,
+				MaxStreams: t.MaxStreams,
+				Warn: func(msg string) {
+					fmt.Fprintln(os.Stderr, color.YellowString("cw: "+msg))
+				},
 			}
-			for le := range ch {
-				out <- &logEvent{logEvent: le, logGroup: group}
+			forward := func(ch <-chan types.FilteredLogEvent) {
+				for le := range ch {
+					out <- &logEvent{logEvent: le, logGroup: group, target: groupStream}
+				}
+			}
+			fail := func(e error) {
+				rnf := &types.ResourceNotFoundException{}
+				if matcher == nil || !errors.As(e, &rnf) {
+					fmt.Fprintln(os.Stderr, e.Error())
+					os.Exit(1)
+				}
+				fmt.Fprintln(os.Stderr, color.YellowString("cw: log group %s is not available anymore", group))
+				matcher.forget(group)
+			}
+
+			if t.Live {
+				ch, errCh := cloudwatch.LiveTail(ctx.Context, &ctx.Client, config, ctx.DebugLog)
+				forward(ch)
+				e := <-errCh
+				if !errors.Is(e, cloudwatch.ErrLiveTailUnavailable) {
+					coordinator.release()
+					if e != nil {
+						fail(e)
+					}
+					return
+				}
+				fmt.Fprintln(os.Stderr, color.YellowString("cw: %v, polling %s instead", e, group))
+				coordinator.add(trigger)
+				coordinator.release()
+			} else {
+				coordinator.add(trigger)
+			}
+
+			ch, errCh := cloudwatch.Tail(ctx.Context, &ctx.Client, config, trigger, ctx.DebugLog)
+			forward(ch)
+			if e := <-errCh; e != nil {
+				fail(e)
 			}
 			coordinator.remove(trigger)
-			wg.Done()
-		}(gs)
-		triggerChannels[idx] = trigger
-		wg.Add(1)
+		}()
+	}
+
+	// targets are added while the coordinator runs: hold it until they all are
+	coordinator.hold()
+	coordinator.start()
+
+	type groupGlob struct {
+		matcher     *groupMatcher
+		groupStream string
+		sel         streamSelector
+	}
+	var globs []groupGlob
+	for idx, gs := range t.LogGroupStreamName {
+		sel := selectors[idx]
+		if !isGroupGlob(sel.group) {
+			startTail(gs, sel, nil)
+			continue
+		}
+		m := newGroupMatcher(sel.group)
+		groups, err := m.refresh(ctx.Context, &ctx.Client)
+		if err != nil {
+			return err
+		}
+		if len(groups) == 0 && !t.Follow {
+			fmt.Fprintln(os.Stderr, color.YellowString("cw: no log group matches %s", sel.group))
+		}
+		for _, g := range groups {
+			startTail(g+gs[len(sel.group):], sel.withGroup(g), m)
+		}
+		globs = append(globs, groupGlob{matcher: m, groupStream: gs, sel: sel})
 	}
 
-	coordinator.start(triggerChannels)
+	if t.Follow {
+		for _, glob := range globs {
+			m, gs, sel := glob.matcher, glob.groupStream, glob.sel
+			coordinator.hold()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer coordinator.release()
+				m.watch(ctx.Context, &ctx.Client, t.GroupRefresh, func(g string) {
+					startTail(g+gs[len(sel.group):], sel.withGroup(g), m)
+				}, ctx.DebugLog)
+			}()
+		}
+	}
+	coordinator.release()
 
 	go func() {
 		wg.Wait()
@@ -267,26 +619,42 @@ func (t *tailCmd) Run(ctx *appContext) error {
 		close(out)
 	}()
 
-	config := formatConfig{
-		PrintTime:       t.PrintTimeStamp,
-		PrintStreamName: t.PrintStreamName,
-		PrintGroupName:  t.PrintGroupName,
-		PrintEventID:    t.PrintEventID,
-	}
-	if t.Query != "" {
-		query, err := jmespath.Compile(t.Query)
-		if err != nil {
-			return fmt.Errorf("failed to parse query as JMESPath query. Query: \"%s\", error: \"%w\"", t.Query, err)
-		}
-		config.Query = query
+	var lags *lagStats
+	if t.LagSummary > 0 {
+		lags = newLagStats()
+		go lags.run(ctx.Context, t.LagSummary, os.Stderr)
 	}
 
-	formatter := logEventFormatter{
-		FormatConfig: config,
-		Log:          ctx.DebugLog}
+	var events <-chan *logEvent = out
+	if !filter.empty() {
+		events = filterEvents(out, filter)
+	}
+	if t.Ordered {
+		events = orderEvents(events, t.OrderDelay)
+	}
 
-	for logEv := range out {
-		fmt.Println(formatter.formatLogMsg(*logEv))
+	for logEv := range events {
+		if err := dest.write(*logEv); err != nil {
+			dest.close()
+			return err
+		}
+		if checkpoints != nil {
+			checkpoints.record(logEv.target, logEv.logEvent)
+		}
+		if lags != nil {
+			if lag, ok := ingestionLag(logEv.logEvent); ok {
+				lags.add(logEv.logGroup, lag)
+			}
+		}
+	}
+	if lags != nil {
+		writeLagSummaries(os.Stderr, lags.flush())
+	}
+	if err := dest.close(); err != nil {
+		return err
+	}
+	if checkpoints != nil {
+		return checkpoints.save()
 	}
 	return nil
 }
@@ -297,7 +665,17 @@ type lsCmd struct {
 }
 
 func (l *lsStreamsCmd) Run(ctx *appContext) error {
-	foundStreams, errorsCh := cloudwatch.LsStreams(&ctx.Client, &l.GroupName, aws.String(""))
+	var activeSince time.Time
+	if l.ActiveSince != "" {
+		t, err := timestampToTime(&l.ActiveSince, false)
+		if err != nil {
+			return fmt.Errorf("can't parse %s as a valid date/time", l.ActiveSince)
+		}
+		activeSince = t
+	}
+
+	var streams []types.LogStream
+	foundStreams, errorsCh := cloudwatch.LsStreams(ctx.Context, &ctx.Client, &l.GroupName, aws.String(""))
 	for {
 		select {
 		case e := <-errorsCh:
@@ -312,9 +690,13 @@ func (l *lsStreamsCmd) Run(ctx *appContext) error {
 			}
 		case msg, ok := <-foundStreams:
 			if ok {
-				fmt.Println(*msg.LogStreamName)
+				streams = append(streams, msg)
 			} else {
-				return nil
+				if ctx.Context.Err() != nil {
+					// interrupted: the listing is incomplete
+					return nil
+				}
+				return writeStreams(os.Stdout, l.Output, l.Long, selectStreams(streams, activeSince, l.Sort, l.Limit))
 			}
 		case <-time.After(5 * time.Second):
 			fmt.Fprintln(os.Stderr, "Unable to fetch log streams.")
@@ -324,10 +706,24 @@ func (l *lsStreamsCmd) Run(ctx *appContext) error {
 }
 
 func (r *lsGroupsCmd) Run(ctx *appContext) error {
-	for msg := range cloudwatch.LsGroups(&ctx.Client) {
-		fmt.Println(*msg)
+	found, errCh := cloudwatch.LsGroups(ctx.Context, &ctx.Client, &r.Prefix, &r.Pattern)
+	if r.Output == "text" && !r.Long && r.Sort == "name" {
+		// groups are already sorted by name: print them as they come
+		for g := range found {
+			fmt.Println(*g.LogGroupName)
+		}
+		return <-errCh
 	}
-	return nil
+
+	var groups []types.LogGroup
+	for g := range found {
+		groups = append(groups, g)
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	sortGroups(groups, r.Sort)
+	return writeGroups(os.Stdout, r.Output, r.Long, groups)
 }
 
 var cli struct {
@@ -340,17 +736,28 @@ var cli struct {
 	NoVersionCheck bool             `name:"no-version-check" help:"Ignore checks if a newer version of the module is available. " default:"false"`
 	Version        kong.VersionFlag `name:"version" help:"Print version information and quit"`
 
-	Ls   lsCmd   `cmd help:"show an entity"`
-	Tail tailCmd `cmd help:"Tail log groups/streams."`
+	Ls           lsCmd           `cmd help:"show an entity"`
+	Tail         tailCmd         `cmd help:"Tail log groups/streams."`
+	Insights     insightsCmd     `cmd help:"Run a CloudWatch Logs Insights query against log groups."`
+	Export       exportCmd       `cmd help:"Export the events of a time window to files, one per stream."`
+	Put          putCmd          `cmd help:"Write the lines read from stdin, or a file, as events of a log stream."`
+	Group        groupCmd        `cmd help:"Manage log groups: create, delete, retention and tags."`
+	MetricFilter metricFilterCmd `cmd name:"metric-filter" help:"Manage metric filters and test filter patterns."`
 }
 
-func main() {
-
-	ctx := kong.Parse(&cli,
-		kong.Vars{"now": time.Now().UTC().Add(-45 * time.Second).Format(timeFormat), "version": version},
+// kongOptions are the options the command line is parsed with
+func kongOptions() []kong.Option {
+	return []kong.Option{
+		kong.Vars{"now": time.Now().UTC().Add(-45 * time.Second).Format(time.RFC3339), "version": version, "levelColors": defaultLevelColors},
 		kong.UsageOnError(),
 		kong.Name("cw"),
-		kong.Description("The best way to tail AWS Cloudwatch Logs from your terminal."))
+		kong.Description("The best way to tail AWS Cloudwatch Logs from your terminal."),
+	}
+}
+
+func main() {
+
+	ctx := kong.Parse(&cli, kongOptions()...)
 
 	debugLog := log.New(io.Discard, "cw [debug] ", log.LstdFlags)
 	if cli.Debug {
@@ -360,13 +767,21 @@ func main() {
 
 	if !cli.NoVersionCheck {
 		defer newVersionMsg(version, fetchLatestVersion())
-		go versionCheckOnSigterm()
 	}
 
 	if cli.NoColor {
 		color.NoColor = true
 	}
-	client := cloudwatch.New(&cli.AwsEndpointURL, &cli.AwsProfile, &cli.AwsRegion, debugLog)
-	err := ctx.Run(&appContext{Debug: cli.Debug, Client: *client, DebugLog: debugLog})
+	runCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		// commands stop gracefully on the first signal, restore the default behaviour for the next one
+		<-runCtx.Done()
+		stop()
+	}()
+
+	client, err := cloudwatch.New(runCtx, &cli.AwsEndpointURL, &cli.AwsProfile, &cli.AwsRegion, debugLog)
+	ctx.FatalIfErrorf(err)
+	err = ctx.Run(&appContext{Context: runCtx, Debug: cli.Debug, Client: *client, DebugLog: debugLog})
 	ctx.FatalIfErrorf(err)
 }
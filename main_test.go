@@ -1,13 +1,33 @@
 package main
 
 import (
-	//"fmt"
+	"fmt"
 
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/alecthomas/kong"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+	"github.com/lucagrulla/cw/cloudwatch/fake"
 	"github.com/stretchr/testify/assert" //"reflect"
 )
 
@@ -116,20 +136,1243 @@ func TestCoordinatorRemoveItem(t *testing.T) {
 	groupTrigger1 := make(chan time.Time, 1)
 	groupTrigger2 := make(chan time.Time, 1)
 
-	channels := []chan<- time.Time{chan<- time.Time(groupTrigger1),
-		chan<- time.Time(groupTrigger2)}
-
 	coordinator := &tailCoordinator{log: log}
-	coordinator.start(channels)
+	coordinator.start()
+	coordinator.add(groupTrigger1)
+	coordinator.add(groupTrigger2)
 
-	coordinator.remove(channels[0])
+	coordinator.remove(groupTrigger1)
+	coordinator.Lock()
+	_, removed := coordinator.find(groupTrigger1)
+	_, kept := coordinator.find(groupTrigger2)
+	coordinator.Unlock()
+	a.Nil(removed)
+	a.NotNil(kept)
 
-	select {
-	case _, ok := <-groupTrigger1:
-		if ok {
+	timeout := time.After(1 * time.Second)
+	for {
+		select {
+		case _, ok := <-groupTrigger1:
+			if !ok {
+				return
+			}
+			// a trigger sent before the removal
+		case <-timeout:
 			a.Fail("Channel should be closed.")
+			return
+		}
+	}
+}
+
+func TestWriteQueryResults(t *testing.T) {
+	a := assert.New(t)
+	rows := [][]types.ResultField{
+		{{Field: aws.String("status"), Value: aws.String("200")}, {Field: aws.String("count"), Value: aws.String("12")}, {Field: aws.String("@ptr"), Value: aws.String("xyz")}},
+		{{Field: aws.String("status"), Value: aws.String("500")}, {Field: aws.String("count"), Value: aws.String("3")}},
+	}
+
+	a.Equal([]string{"status", "count"}, queryResultColumns(rows))
+
+	var b strings.Builder
+	a.NoError(writeQueryResults(&b, "csv", rows))
+	a.Equal("status,count\n200,12\n500,3\n", b.String())
+
+	b.Reset()
+	a.NoError(writeQueryResults(&b, "table", rows))
+	a.Equal("status  count\n200     12\n500     3\n", b.String())
+
+	b.Reset()
+	a.NoError(writeQueryResults(&b, "json", rows))
+	a.JSONEq(`[{"status":"200","count":"12"},{"status":"500","count":"3"}]`, b.String())
+}
+
+func TestParseInsightsCommandLine(t *testing.T) {
+	a := assert.New(t)
+	parser, err := kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"insights", "g", "-q", "fields @message"})
+	a.NoError(err)
+	a.Equal([]string{"g"}, cli.Insights.LogGroupNames)
+	a.Equal("fields @message", cli.Insights.Query)
+	a.Equal("", cli.Insights.QueryFile)
+
+	queryFile, err := os.CreateTemp(t.TempDir(), "query")
+	a.NoError(err)
+	queryFile.Close()
+	// a new parser over a reset command, the previous parse has set --query
+	cli.Insights = insightsCmd{}
+	parser, err = kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"insights", "g", "--query-file", queryFile.Name()})
+	a.NoError(err)
+	a.Equal(queryFile.Name(), cli.Insights.QueryFile)
+	_, err = parser.Parse([]string{"insights", "g", "-q", "fields @message", "--query-file", queryFile.Name()})
+	a.Error(err)
+}
+
+func TestStructuredOutput(t *testing.T) {
+	a := assert.New(t)
+	formatter := logEventFormatter{Log: log.New(io.Discard, "", log.LstdFlags)}
+	ev := logEvent{logGroup: "group", logEvent: types.FilteredLogEvent{
+		EventId:       aws.String("1"),
+		LogStreamName: aws.String("stream"),
+		Timestamp:     aws.Int64(1600000000123),
+		IngestionTime: aws.Int64(1600000001000),
+		Message:       aws.String(`{"level":"info"}`),
+	}}
+
+	var b strings.Builder
+	a.NoError(newEventWriter(&b, "ndjson", formatter).write(ev))
+	a.JSONEq(`{"timestamp":"2020-09-13T12:26:40.123Z","ingestionTime":"2020-09-13T12:26:41.000Z","group":"group","stream":"stream","eventId":"1","message":{"level":"info"}}`, b.String())
+
+	ev.logEvent.Message = aws.String("plain text")
+	b.Reset()
+	a.NoError(newEventWriter(&b, "ndjson", formatter).write(ev))
+	a.Contains(b.String(), `"message":"plain text"`)
+
+	b.Reset()
+	a.NoError(newEventWriter(&b, "logfmt", formatter).write(ev))
+	a.Equal("timestamp=2020-09-13T12:26:40.123Z ingestion_time=2020-09-13T12:26:41.000Z group=group stream=stream event_id=1 message=\"plain text\"\n", b.String())
+
+	b.Reset()
+	w := newEventWriter(&b, "csv", formatter)
+	a.NoError(w.write(ev))
+	a.NoError(w.write(ev))
+	a.Equal("timestamp,ingestion_time,group,stream,event_id,message\n"+
+		"2020-09-13T12:26:40.123Z,2020-09-13T12:26:41.000Z,group,stream,1,plain text\n"+
+		"2020-09-13T12:26:40.123Z,2020-09-13T12:26:41.000Z,group,stream,1,plain text\n", b.String())
+}
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// drain collects the triggers sent by the coordinator and reports back the given poll outcome
+func drain(coordinator *tailCoordinator, triggers map[chan<- time.Time]chan time.Time, stats func(chan<- time.Time) cloudwatch.PollStats, counts map[chan<- time.Time]int) {
+	for send, recv := range triggers {
+		select {
+		case <-recv:
+			counts[send]++
+			coordinator.done(send, stats(send))
+		default:
+		}
+	}
+}
+
+func newTestCoordinator(rate float64, maxConcurrency int, n int) (*tailCoordinator, *fakeClock, []chan<- time.Time, map[chan<- time.Time]chan time.Time) {
+	clk := &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	coordinator := &tailCoordinator{log: log.New(io.Discard, "", log.LstdFlags), rate: rate, maxConcurrency: maxConcurrency, clock: clk}
+	triggers := make(map[chan<- time.Time]chan time.Time)
+	var channels []chan<- time.Time
+	for i := 0; i < n; i++ {
+		c := make(chan time.Time, 1)
+		channels = append(channels, c)
+		triggers[c] = c
+	}
+	coordinator.init()
+	for _, c := range channels {
+		coordinator.targets = append(coordinator.targets, &tailTarget{trigger: c})
+	}
+	return coordinator, clk, channels, triggers
+}
+
+func TestCoordinatorRespectsRequestBudget(t *testing.T) {
+	a := assert.New(t)
+	coordinator, clk, _, triggers := newTestCoordinator(2, 4, 3)
+	counts := make(map[chan<- time.Time]int)
+	idle := func(chan<- time.Time) cloudwatch.PollStats { return cloudwatch.PollStats{} }
+
+	for i := 0; i < 100; i++ { // 10 seconds
+		coordinator.schedule()
+		drain(coordinator, triggers, idle, counts)
+		clk.Advance(100 * time.Millisecond)
+	}
+	total := 0
+	for _, c := range counts {
+		a.Greater(c, 0, "every target should be polled")
+		total += c
+	}
+	a.InDelta(20, total, 2, "2 req/sec for 10 seconds")
+}
+
+func TestCoordinatorFavoursActiveTargets(t *testing.T) {
+	a := assert.New(t)
+	coordinator, clk, channels, triggers := newTestCoordinator(5, 4, 4)
+	counts := make(map[chan<- time.Time]int)
+	active := channels[0]
+	stats := func(c chan<- time.Time) cloudwatch.PollStats {
+		if c == active {
+			return cloudwatch.PollStats{Events: 10}
+		}
+		return cloudwatch.PollStats{}
+	}
+
+	for i := 0; i < 100; i++ {
+		coordinator.schedule()
+		drain(coordinator, triggers, stats, counts)
+		clk.Advance(100 * time.Millisecond)
+	}
+	for _, c := range channels[1:] {
+		a.Greater(counts[c], 0, "idle targets must not starve")
+		a.Greater(counts[active], 2*counts[c])
+	}
+}
+
+func TestCoordinatorBacksOffWhenThrottled(t *testing.T) {
+	a := assert.New(t)
+	coordinator, clk, _, triggers := newTestCoordinator(4, 4, 2)
+	counts := make(map[chan<- time.Time]int)
+	throttled := func(chan<- time.Time) cloudwatch.PollStats { return cloudwatch.PollStats{Throttled: true} }
+
+	for i := 0; i < 50; i++ {
+		coordinator.schedule()
+		drain(coordinator, triggers, throttled, counts)
+		clk.Advance(100 * time.Millisecond)
+	}
+	a.Equal(minRate, coordinator.currentRate)
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	a.Less(total, 10, "throttled coordinator should slow down to the minimum rate")
+
+	ok := func(chan<- time.Time) cloudwatch.PollStats { return cloudwatch.PollStats{Events: 1} }
+	for i := 0; i < 300; i++ {
+		coordinator.schedule()
+		drain(coordinator, triggers, ok, counts)
+		clk.Advance(100 * time.Millisecond)
+	}
+	a.Equal(4.0, coordinator.currentRate, "rate should recover once throttling stops")
+}
+
+func TestCoordinatorChargesEveryRequestOfAPoll(t *testing.T) {
+	a := assert.New(t)
+	coordinator, clk, _, triggers := newTestCoordinator(2, 4, 3)
+	counts := make(map[chan<- time.Time]int)
+	// 3 shards of 100 streams: every poll takes 3 requests
+	sharded := func(chan<- time.Time) cloudwatch.PollStats { return cloudwatch.PollStats{Requests: 3} }
+
+	for i := 0; i < 100; i++ { // 10 seconds
+		coordinator.schedule()
+		drain(coordinator, triggers, sharded, counts)
+		clk.Advance(100 * time.Millisecond)
+	}
+	total := 0
+	for _, c := range counts {
+		total += c
+	}
+	a.InDelta(20, 3*total, 4, "2 req/sec for 10 seconds, 3 requests per poll")
+}
+
+func TestCoordinatorMaxConcurrency(t *testing.T) {
+	a := assert.New(t)
+	coordinator, clk, _, triggers := newTestCoordinator(10, 2, 5)
+
+	for i := 0; i < 10; i++ {
+		coordinator.schedule()
+		clk.Advance(time.Second)
+	}
+	pending := 0
+	for _, recv := range triggers {
+		if len(recv) > 0 {
+			pending++
+		}
+	}
+	a.Equal(2, pending)
+	a.Equal(2, coordinator.inFlight)
+}
+
+func TestCheckpointStore(t *testing.T) {
+	a := assert.New(t)
+	path := filepath.Join(t.TempDir(), "cw.checkpoint")
+	l := log.New(io.Discard, "", log.LstdFlags)
+
+	store, err := loadCheckpoints(path, l)
+	a.NoError(err)
+	a.Nil(store.get("group:prefix"))
+
+	ev := func(id string, ts int64) types.FilteredLogEvent {
+		return types.FilteredLogEvent{EventId: aws.String(id), Timestamp: aws.Int64(ts)}
+	}
+	store.record("group:prefix", ev("1", 100))
+	store.record("group:prefix", ev("2", 200))
+	store.record("group:prefix", ev("3", 200))
+	store.record("group:prefix", ev("0", 50))
+	store.record("other", ev("9", 10))
+	a.NoError(store.save())
+
+	reloaded, err := loadCheckpoints(path, l)
+	a.NoError(err)
+	a.Equal(&cloudwatch.Checkpoint{Timestamp: 200, EventIDs: []string{"2", "3"}}, reloaded.get("group:prefix"))
+	a.Equal(&cloudwatch.Checkpoint{Timestamp: 10, EventIDs: []string{"9"}}, reloaded.get("other"))
+}
+
+func TestCheckpointStoreReportsSaveFailures(t *testing.T) {
+	a := assert.New(t)
+	color.NoColor = true
+	store, err := loadCheckpoints(filepath.Join(t.TempDir(), "cw.checkpoint"), log.New(io.Discard, "", log.LstdFlags))
+	a.NoError(err)
+	store.flush = func() error { return errors.New("disk full") }
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		store.run(ctx, 5*time.Millisecond, &out)
+		done <- true
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+	a.Equal(1, strings.Count(out.String(), "disk full"), "a failure is reported once, not at every save")
+	a.Contains(out.String(), "can't save the checkpoints")
+}
+
+func TestEventFilter(t *testing.T) {
+	a := assert.New(t)
+
+	f, err := newEventFilter(nil, nil, nil, false)
+	a.NoError(err)
+	a.True(f.empty())
+
+	f, err = newEventFilter([]string{"ERROR", "timeout"}, []string{"healthcheck"}, nil, false)
+	a.NoError(err)
+	a.True(f.matches("ERROR upstream timeout"))
+	a.False(f.matches("ERROR upstream refused"), "all match rules must be satisfied")
+	a.False(f.matches("ERROR healthcheck timeout"), "exclude rules win")
+
+	f, err = newEventFilter([]string{"ERROR", "WARN"}, nil, nil, true)
+	a.NoError(err)
+	a.True(f.matches("WARN disk at 80%"))
+	a.False(f.matches("INFO started"))
+
+	f, err = newEventFilter(nil, nil, []string{"level=^(error|warn)$", "http.status=^5"}, false)
+	a.NoError(err)
+	a.True(f.matches(`{"level":"error","http":{"status":503}}`))
+	a.False(f.matches(`{"level":"error","http":{"status":200}}`))
+	a.False(f.matches(`{"level":"info","http":{"status":503}}`))
+	a.False(f.matches("level=error not json"), "field rules only apply to JSON messages")
+
+	f, err = newEventFilter([]string{"panic"}, nil, []string{"level=error"}, true)
+	a.NoError(err)
+	a.True(f.matches(`{"level":"error"}`))
+	a.True(f.matches("panic: runtime error"))
+	a.False(f.matches(`{"level":"info"}`))
+
+	_, err = newEventFilter(nil, nil, []string{"no-separator"}, false)
+	a.Error(err)
+	_, err = newEventFilter([]string{"("}, nil, nil, false)
+	a.Error(err)
+}
+
+func TestFilterEventsStage(t *testing.T) {
+	f, _ := newEventFilter(nil, []string{"drop"}, nil, false)
+	in := make(chan *logEvent, 3)
+	for _, m := range []string{"keep 1", "drop", "keep 2"} {
+		in <- &logEvent{logEvent: types.FilteredLogEvent{Message: aws.String(m)}}
+	}
+	close(in)
+
+	var kept []string
+	for ev := range filterEvents(in, f) {
+		kept = append(kept, *ev.logEvent.Message)
+	}
+	assert.Equal(t, []string{"keep 1", "keep 2"}, kept)
+}
+
+func TestHighlighter(t *testing.T) {
+	a := assert.New(t)
+	noColor := color.NoColor
+	color.NoColor = false
+	defer func() { color.NoColor = noColor }()
+
+	red := color.New(color.FgRed)
+	match := color.New(color.FgBlack, color.BgYellow)
+
+	h, err := newHighlighter([]string{"user-[0-9]+"}, "error=red,warn=yellow")
+	a.NoError(err)
+
+	a.Equal("2020 "+red.Sprint("ERROR")+" login failed for "+match.Sprint("user-42"),
+		h.apply("2020 ERROR login failed for user-42"))
+	a.Equal(`{"level":"`+red.Sprint("error")+`","msg":"boom"}`, h.apply(`{"level":"error","msg":"boom"}`))
+	a.Equal("INFO not in the scheme", h.apply("INFO not in the scheme"))
+	a.Equal("no ERRORS here", h.apply("no ERRORS here"), "levels are matched as whole words")
+
+	h, err = newHighlighter(nil, "none")
+	a.NoError(err)
+	a.True(h.empty())
+
+	_, err = newHighlighter(nil, "error=purple")
+	a.Error(err)
+	_, err = newHighlighter(nil, "fatal")
+	a.Error(err)
+	_, err = newHighlighter(nil, "warning=bold-hi-yellow")
+	a.NoError(err)
+}
+
+func TestFormatTemplate(t *testing.T) {
+	a := assert.New(t)
+	tmpl, err := newEventTemplate(`{{.Timestamp.UTC | fmtTime "15:04:05.000"}} [{{.JSON.level | default "-"}}] {{.Group}}/{{.Stream}} {{truncate 10 .Message}}`, nil)
+	a.NoError(err)
+	formatter := logEventFormatter{Log: log.New(io.Discard, "", log.LstdFlags), FormatConfig: formatConfig{Template: tmpl, PrintTime: true}}
+
+	ev := logEvent{logGroup: "group", logEvent: types.FilteredLogEvent{
+		EventId:       aws.String("1"),
+		LogStreamName: aws.String("stream"),
+		Timestamp:     aws.Int64(1600000000123),
+		Message:       aws.String(`{"level":"warn","msg":"disk almost full"}`),
+	}}
+	a.Equal(`12:26:40.123 [warn] group/stream {"level...`, formatter.formatLogMsg(ev))
+
+	ev.logEvent.Message = aws.String("plain")
+	a.Equal(`12:26:40.123 [-] group/stream plain`, formatter.formatLogMsg(ev))
+
+	// missing JSON fields print nothing, also without default and within if
+	tmpl, err = newEventTemplate(`[{{.JSON.level}}]{{if .Message}} {{.JSON.msg}}{{.Message}}{{end}}`, nil)
+	a.NoError(err)
+	formatter.FormatConfig.Template = tmpl
+	a.Equal(`[] plain`, formatter.formatLogMsg(ev))
+	ev.logEvent.Message = aws.String(`{"level":"info","msg":"ok "}`)
+	a.Equal(`[info] ok {"level":"info","msg":"ok "}`, formatter.formatLogMsg(ev))
+
+	// also through functions, and values which are not strings are printed as JSON
+	tmpl, err = newEventTemplate(`{{.JSON.x | printf "%s"}}|{{.JSON.n}}|{{.JSON.obj}}|{{.JSON.null | default "-"}}|{{if eq .JSON.level "info"}}info{{end}}`, nil)
+	a.NoError(err)
+	formatter.FormatConfig.Template = tmpl
+	ev.logEvent.Message = aws.String(`{"level":"info","n":1.5,"obj":{"a":[1,2]},"null":null}`)
+	a.Equal(`|1.5|{"a":[1,2]}|-|info`, formatter.formatLogMsg(ev))
+	ev.logEvent.Message = aws.String("plain")
+	a.Equal(`|||-|`, formatter.formatLogMsg(ev))
+
+	_, err = newEventTemplate("{{.Message", nil)
+	a.Error(err)
+}
+
+func TestTimestampToTimeInZone(t *testing.T) {
+	a := assert.New(t)
+	zone := time.FixedZone("UTC+2", 2*60*60)
+
+	s := "2017-03-12T18:22"
+	parsed, err := timestampToTimeIn(&s, zone)
+	a.NoError(err)
+	a.Equal(time.Date(2017, 3, 12, 16, 22, 0, 0, time.UTC), parsed.UTC())
+
+	s = "2017-03-12T18:22:23.123Z"
+	parsed, err = timestampToTimeIn(&s, zone)
+	a.NoError(err)
+	a.Equal(time.Date(2017, 3, 12, 18, 22, 23, 123000000, time.UTC), parsed.UTC(), "RFC3339 timestamps carry their own zone")
+
+	loc, err := timeZone("", true)
+	a.NoError(err)
+	a.Equal(time.Local, loc)
+	loc, err = timeZone("UTC", true)
+	a.NoError(err)
+	a.Equal("UTC", loc.String())
+	_, err = timeZone("Not/AZone", false)
+	a.Error(err)
+}
+
+func TestTailPrintsTimestampsInTheParsingZone(t *testing.T) {
+	a := assert.New(t)
+	cmd := tailCmd{}
+	zone, err := timeZone(cmd.TZ, cmd.Local)
+	a.NoError(err)
+	a.Equal(time.UTC, cmd.formatConfig(zone).Location, "without --tz or --local both are UTC")
+
+	cmd.Local = true
+	zone, err = timeZone(cmd.TZ, cmd.Local)
+	a.NoError(err)
+	a.Equal(time.Local, cmd.formatConfig(zone).Location)
+
+	cmd.TZ = "Europe/Rome"
+	zone, err = timeZone(cmd.TZ, cmd.Local)
+	a.NoError(err)
+	formatter := logEventFormatter{FormatConfig: cmd.formatConfig(zone)}
+	a.Equal("2020-09-13T14:26:40", formatter.formatTimestamp(1600000000123))
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	a := assert.New(t)
+	formatter := logEventFormatter{FormatConfig: formatConfig{Location: time.FixedZone("UTC+2", 2*60*60)}}
+	var millis int64 = 1600000000123
+
+	a.Equal("2020-09-13T14:26:40", formatter.formatTimestamp(millis))
+	formatter.FormatConfig.TimeFormat = "rfc3339nano"
+	a.Equal("2020-09-13T14:26:40.123+02:00", formatter.formatTimestamp(millis))
+	formatter.FormatConfig.TimeFormat = "epochms"
+	a.Equal("1600000000123", formatter.formatTimestamp(millis))
+	formatter.FormatConfig.TimeFormat = "15:04:05.000 MST"
+	a.Equal("14:26:40.123 UTC+2", formatter.formatTimestamp(millis))
+
+	now := time.Now()
+	a.Equal("3s ago", relativeTime(now, now.Add(-3*time.Second)))
+	a.Equal("250ms ago", relativeTime(now, now.Add(-250*time.Millisecond)))
+	a.Equal("in 2m0s", relativeTime(now, now.Add(2*time.Minute)))
+}
+
+func TestLagStats(t *testing.T) {
+	a := assert.New(t)
+	stats := newLagStats()
+	for i := 1; i <= 100; i++ {
+		stats.add("group-a", time.Duration(i)*time.Millisecond)
+	}
+	stats.add("group-b", 2*time.Second)
+
+	summaries := stats.flush()
+	a.Equal([]lagSummary{
+		{group: "group-a", count: 100, p50: 50 * time.Millisecond, p90: 90 * time.Millisecond, p99: 99 * time.Millisecond, max: 100 * time.Millisecond},
+		{group: "group-b", count: 1, p50: 2 * time.Second, p90: 2 * time.Second, p99: 2 * time.Second, max: 2 * time.Second},
+	}, summaries)
+	a.Empty(stats.flush(), "flush resets the samples")
+
+	// past maxLagSamples the samples may not hold the largest lag anymore
+	stats.add("group-a", time.Hour)
+	for i := 0; i < 10*maxLagSamples; i++ {
+		stats.add("group-a", time.Millisecond)
+	}
+	sampled := stats.flush()
+	a.Equal(time.Hour, sampled[0].max)
+	a.Equal(10*maxLagSamples+1, sampled[0].count)
+
+	var b strings.Builder
+	writeLagSummaries(&b, summaries[1:])
+	a.Equal("cw: ingestion lag group-b: events=1 p50=2s p90=2s p99=2s max=2s\n", b.String())
+
+	lag, ok := ingestionLag(types.FilteredLogEvent{Timestamp: aws.Int64(1000), IngestionTime: aws.Int64(2500)})
+	a.True(ok)
+	a.Equal("+1.5s", formatLag(lag))
+	_, ok = ingestionLag(types.FilteredLogEvent{Timestamp: aws.Int64(1000)})
+	a.False(ok)
+}
+
+func TestWriteGroups(t *testing.T) {
+	a := assert.New(t)
+	groups := []types.LogGroup{
+		{LogGroupName: aws.String("b"), StoredBytes: aws.Int64(2048), CreationTime: aws.Int64(1000), RetentionInDays: aws.Int32(7)},
+		{LogGroupName: aws.String("a"), StoredBytes: aws.Int64(10), CreationTime: aws.Int64(5000), KmsKeyId: aws.String("key"), MetricFilterCount: aws.Int32(2)},
+	}
+
+	sortGroups(groups, "age")
+	a.Equal("b", *groups[0].LogGroupName)
+	sortGroups(groups, "name")
+	a.Equal("a", *groups[0].LogGroupName)
+	sortGroups(groups, "size")
+	a.Equal("b", *groups[0].LogGroupName)
+
+	var b strings.Builder
+	a.NoError(writeGroups(&b, "csv", false, groups))
+	a.Equal("name,stored_bytes,retention_days,creation_time,metric_filter_count,kms_key_id\n"+
+		"b,2048,7,1970-01-01T00:00:01Z,0,\n"+
+		"a,10,,1970-01-01T00:00:05Z,2,key\n", b.String())
+
+	b.Reset()
+	a.NoError(writeGroups(&b, "json", false, groups[1:]))
+	a.JSONEq(`[{"name":"a","storedBytes":10,"retentionInDays":null,"creationTime":"1970-01-01T00:00:05Z","metricFilterCount":2,"kmsKeyId":"key"}]`, b.String())
+
+	b.Reset()
+	a.NoError(writeGroups(&b, "text", true, groups))
+	a.Contains(b.String(), "2.0 KiB")
+	a.Contains(b.String(), "never expire")
+
+	a.Equal("512 B", formatBytes(512))
+	a.Equal("1.5 MiB", formatBytes(3*512*1024))
+}
+
+func TestSelectStreams(t *testing.T) {
+	a := assert.New(t)
+	now := time.Now()
+	streams := []types.LogStream{
+		{LogStreamName: aws.String("a"), StoredBytes: aws.Int64(30), LastEventTimestamp: aws.Int64(now.Add(-3 * time.Hour).UnixMilli())},
+		{LogStreamName: aws.String("c"), StoredBytes: aws.Int64(10), LastEventTimestamp: aws.Int64(now.Add(-time.Minute).UnixMilli())},
+		{LogStreamName: aws.String("b"), StoredBytes: aws.Int64(20), LastIngestionTime: aws.Int64(now.Add(-time.Hour).UnixMilli())},
+	}
+	names := func(streams []types.LogStream) []string {
+		var res []string
+		for _, s := range streams {
+			res = append(res, *s.LogStreamName)
 		}
-	case <-time.After(1 * time.Second):
-		a.Fail("Timeout")
+		return res
 	}
+
+	a.Equal([]string{"a", "b", "c"}, names(selectStreams(streams, time.Time{}, "name", 0)))
+	a.Equal([]string{"c", "b", "a"}, names(selectStreams(streams, time.Time{}, "last-event", 0)))
+	a.Equal([]string{"a", "b"}, names(selectStreams(streams, time.Time{}, "name", 2)))
+	a.Equal([]string{"c", "b"}, names(selectStreams(streams, now.Add(-2*time.Hour), "last-event", 0)))
+
+	var b strings.Builder
+	a.NoError(writeStreams(&b, "json", false, []types.LogStream{{LogStreamName: aws.String("a"), StoredBytes: aws.Int64(1), LastEventTimestamp: aws.Int64(0)}}))
+	a.JSONEq(`[{"name":"a","creationTime":"","firstEventTimestamp":"","lastEventTimestamp":"1970-01-01T00:00:00Z","lastIngestionTime":""}]`, b.String(), "CloudWatch reports 0 stored bytes for streams")
+	b.Reset()
+	a.NoError(writeStreams(&b, "text", true, []types.LogStream{{LogStreamName: aws.String("a"), StoredBytes: aws.Int64(1), LastEventTimestamp: aws.Int64(0)}}))
+	a.Equal("NAME  CREATED  LAST EVENT            LAST INGESTION\na              1970-01-01T00:00:00Z  \n", b.String())
+}
+
+func TestParseStreamSelector(t *testing.T) {
+	a := assert.New(t)
+
+	sel, err := parseStreamSelector("group")
+	a.NoError(err)
+	a.Equal(streamSelector{group: "group"}, sel)
+
+	sel, _ = parseStreamSelector("group:*")
+	a.Equal(streamSelector{group: "group"}, sel)
+
+	sel, _ = parseStreamSelector("group:web")
+	a.Equal(streamSelector{group: "group", prefix: "web"}, sel)
+
+	sel, _ = parseStreamSelector("group:ecs/*/api/?")
+	a.Equal("ecs/", sel.prefix)
+	a.True(sel.pattern.MatchString("ecs/task/api/1"))
+	a.False(sel.pattern.MatchString("ecs/task/api/12"))
+	a.False(sel.pattern.MatchString("ecs/task/web/1"))
+
+	sel, _ = parseStreamSelector("group:~^web\\.[a-z]+-blue$")
+	a.Equal("web.", sel.prefix)
+	a.True(sel.pattern.MatchString("web.api-blue"))
+	a.False(sel.pattern.MatchString("web.api-green"))
+
+	sel, _ = parseStreamSelector("group:~a:b")
+	a.Equal("", sel.prefix, "unanchored regex can match anywhere in the name")
+	a.True(sel.pattern.MatchString("xa:b"))
+
+	_, err = parseStreamSelector("group:~(")
+	a.Error(err)
+}
+
+func TestGroupMatcher(t *testing.T) {
+	a := assert.New(t)
+	backend := fake.New()
+	backend.AddGroup("/aws/lambda/orders-main")
+	backend.AddGroup("/aws/lambda/orders-feature-x")
+	backend.AddGroup("/aws/lambda/payments-main")
+
+	m := newGroupMatcher("/aws/lambda/orders-*")
+	a.Equal("/aws/lambda/orders-", m.prefix)
+	groups, err := m.refresh(context.Background(), backend)
+	a.NoError(err)
+	a.ElementsMatch([]string{"/aws/lambda/orders-main", "/aws/lambda/orders-feature-x"}, groups)
+
+	backend.AddGroup("/aws/lambda/orders-feature-y")
+	groups, err = m.refresh(context.Background(), backend)
+	a.NoError(err)
+	a.Equal([]string{"/aws/lambda/orders-feature-y"}, groups, "only the new groups are returned")
+
+	// the tail of a deleted group forgets it: the group is tailed again once recreated
+	_, err = backend.DeleteLogGroup(context.Background(), &cloudwatchlogs.DeleteLogGroupInput{LogGroupName: aws.String("/aws/lambda/orders-feature-y")})
+	a.NoError(err)
+	m.forget("/aws/lambda/orders-feature-y")
+	groups, _ = m.refresh(context.Background(), backend)
+	a.Empty(groups)
+	backend.AddGroup("/aws/lambda/orders-feature-y")
+	groups, _ = m.refresh(context.Background(), backend)
+	a.Equal([]string{"/aws/lambda/orders-feature-y"}, groups)
+
+	m = newGroupMatcher("*main*")
+	a.Equal("", m.prefix)
+	a.Equal("main", m.pattern)
+	groups, _ = m.refresh(context.Background(), backend)
+	a.ElementsMatch([]string{"/aws/lambda/orders-main", "/aws/lambda/payments-main"}, groups)
+
+	m = newGroupMatcher("*/orders-?ain")
+	a.Equal("", m.pattern)
+	groups, _ = m.refresh(context.Background(), backend)
+	a.Equal([]string{"/aws/lambda/orders-main"}, groups)
+}
+
+func TestCoordinatorHoldsWithoutTargets(t *testing.T) {
+	a := assert.New(t)
+	coordinator, _, _, _ := newTestCoordinator(5, 4, 0)
+	a.False(coordinator.schedule(), "no targets and none to come")
+
+	coordinator.hold()
+	a.True(coordinator.schedule(), "targets can still be added")
+
+	trigger := make(chan time.Time, 1)
+	coordinator.add(trigger)
+	coordinator.release()
+	a.True(coordinator.schedule())
+	a.Len(trigger, 1)
+
+	coordinator.remove(trigger)
+	a.False(coordinator.schedule())
+}
+
+func TestOrderEvents(t *testing.T) {
+	a := assert.New(t)
+	ev := func(group string, ts int64) *logEvent {
+		return &logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(ts), Message: aws.String(fmt.Sprintf("%s-%d", group, ts))}, logGroup: group}
+	}
+	in := make(chan *logEvent)
+	out := orderEvents(in, 50*time.Millisecond)
+
+	in <- ev("a", 10)
+	in <- ev("a", 30)
+	in <- ev("b", 20)
+	in <- ev("b", 5)
+	var got []string
+	for i := 0; i < 4; i++ {
+		got = append(got, *(<-out).logEvent.Message)
+	}
+	a.Equal([]string{"b-5", "a-10", "b-20", "a-30"}, got)
+
+	// an event later than the watermark is emitted out of order
+	in <- ev("a", 40)
+	a.Equal("a-40", *(<-out).logEvent.Message)
+	in <- ev("b", 35)
+	a.Equal("b-35", *(<-out).logEvent.Message)
+
+	// held events are flushed in order when the input is closed
+	in <- ev("a", 60)
+	in <- ev("b", 50)
+	close(in)
+	a.Equal("b-50", *(<-out).logEvent.Message)
+	a.Equal("a-60", *(<-out).logEvent.Message)
+	_, ok := <-out
+	a.False(ok)
+}
+
+func TestExporterResumesFromCheckpoint(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	l := log.New(io.Discard, "", log.LstdFlags)
+	color.NoColor = true
+
+	newExporter := func() (*exporter, bool) {
+		state, resumed, err := loadExportState(dir, "group 1h", l)
+		a.NoError(err)
+		return &exporter{
+			dir:       dir,
+			output:    "ndjson",
+			gzip:      true,
+			formatter: logEventFormatter{Log: l},
+			state:     state,
+			files:     make(map[string]*exportFile),
+			lastSave:  time.Now(),
+		}, resumed
+	}
+	ev := func(id string, ts int64) types.FilteredLogEvent {
+		return types.FilteredLogEvent{EventId: aws.String(id), Timestamp: aws.Int64(ts), LogStreamName: aws.String("2023/01/01/[$LATEST]abc"), Message: aws.String("message " + id)}
+	}
+
+	x, resumed := newExporter()
+	a.False(resumed)
+	a.NoError(x.write("group", "/aws/lambda/fn", ev("1", 100)))
+	a.NoError(x.checkpoint())
+	a.NoError(x.write("group", "/aws/lambda/fn", ev("2", 200)))
+	a.NoError(x.checkpoint())
+	// written after the last checkpoint, lost when interrupted
+	a.NoError(x.write("group", "/aws/lambda/fn", ev("3", 300)))
+	for _, f := range x.files {
+		f.buf.Flush()
+		f.gz.Close()
+		f.f.Close()
+	}
+
+	x, resumed = newExporter()
+	a.True(resumed)
+	a.Equal(&cloudwatch.Checkpoint{Timestamp: 200, EventIDs: []string{"2"}}, x.state.Checkpoints.get("group"))
+	a.NoError(x.write("group", "/aws/lambda/fn", ev("3", 300)))
+	a.NoError(x.close())
+
+	path := filepath.Join(dir, "aws_lambda_fn", "2023_01_01_LATEST_abc.ndjson.gz")
+	f, err := os.Open(path)
+	a.NoError(err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	a.NoError(err)
+	b, err := io.ReadAll(r)
+	a.NoError(err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	a.Len(lines, 3)
+	for i, line := range lines {
+		a.Contains(line, fmt.Sprintf("message %d", i+1))
+	}
+
+	_, resumed, err = loadExportState(dir, "group 2h", l)
+	a.NoError(err)
+	a.False(resumed, "an export with different arguments starts over")
+}
+
+func TestExporterBoundsOpenFiles(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	l := log.New(io.Discard, "", log.LstdFlags)
+	color.NoColor = true
+
+	state, _, err := loadExportState(dir, "group 1h", l)
+	a.NoError(err)
+	x := &exporter{
+		dir:          dir,
+		output:       "text",
+		formatter:    logEventFormatter{Log: l},
+		state:        state,
+		files:        make(map[string]*exportFile),
+		lastSave:     time.Now(),
+		maxOpenFiles: 2,
+	}
+	// every stream is written twice: the second time its file has been closed to open the others
+	for _, round := range []string{"first", "second"} {
+		for i := 0; i < 10; i++ {
+			stream := fmt.Sprintf("stream-%d", i)
+			ev := types.FilteredLogEvent{EventId: aws.String(round + stream), Timestamp: aws.Int64(int64(i)), LogStreamName: aws.String(stream), Message: aws.String(round)}
+			a.NoError(x.write("group", "group", ev))
+			a.LessOrEqual(len(x.files), 2)
+		}
+	}
+	a.Len(x.files, 2, "only the least recently written file is closed")
+	a.Len(x.state.Files, 10, "the size of every closed file is recorded")
+	_, err = os.Stat(filepath.Join(dir, exportStateFile))
+	a.True(os.IsNotExist(err), "closing a file does not save the state")
+	a.NoError(x.close())
+
+	for i := 0; i < 10; i++ {
+		b, err := os.ReadFile(filepath.Join(dir, "group", fmt.Sprintf("stream-%d.log", i)))
+		a.NoError(err)
+		a.Equal([]string{"first", "second"}, regexp.MustCompile(`first|second`).FindAllString(string(b), -1))
+	}
+}
+
+func TestFileSinkRotates(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	l := log.New(io.Discard, "", log.LstdFlags)
+	color.NoColor = true
+
+	size, err := parseSize("100MB")
+	a.NoError(err)
+	a.Equal(int64(100*1024*1024), size)
+	_, err = parseSize("lots")
+	a.Error(err)
+
+	newFileSink := func() *fileSink {
+		s, err := newSink("file://"+dir+"?max-size=10B&compress=gzip", "text", logEventFormatter{Log: l}, l)
+		a.NoError(err)
+		return s.(*fileSink)
+	}
+	ev := func(ts int64, msg string) logEvent {
+		return logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(ts), LogStreamName: aws.String("web/1"), Message: aws.String(msg)}, logGroup: "/ecs/web"}
+	}
+	files := func() []string {
+		names, err := filepath.Glob(filepath.Join(dir, "ecs_web", "*"))
+		a.NoError(err)
+		for i, n := range names {
+			names[i] = filepath.Base(n)
+		}
+		return names
+	}
+
+	s := newFileSink()
+	a.Equal(filepath.Join(dir, ".cw-checkpoint.json"), s.checkpointPath())
+	a.NoError(s.write(ev(1000, "first message")))
+	a.NoError(s.write(ev(2000, "second")))
+	a.NoError(s.flush())
+	a.Equal([]string{"web_1.19700101T000001.000Z-19700101T000001.000Z.log.gz", "web_1.19700101T000002.000Z.log"}, files())
+
+	// the file left open is continued by the next run, and rotated on close
+	s = newFileSink()
+	a.NoError(s.write(ev(3000, "third")))
+	a.NoError(s.close())
+	a.Equal([]string{"web_1.19700101T000001.000Z-19700101T000001.000Z.log.gz", "web_1.19700101T000002.000Z-19700101T000003.000Z.log.gz"}, files())
+
+	f, err := os.Open(filepath.Join(dir, "ecs_web", "web_1.19700101T000002.000Z-19700101T000003.000Z.log.gz"))
+	a.NoError(err)
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	a.NoError(err)
+	b, err := io.ReadAll(r)
+	a.NoError(err)
+	a.Equal("second\nthird\n", string(b))
+
+	_, err = newSink("s3://bucket", "text", logEventFormatter{Log: l}, l)
+	a.Error(err)
+}
+
+func TestFileSinkDropsFilesFailingToRotate(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	l := log.New(io.Discard, "", log.LstdFlags)
+	color.NoColor = true
+
+	s, err := newSink("file://"+dir+"?max-age=1ms", "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	fs := s.(*fileSink)
+	ev := func(ts int64, msg string) logEvent {
+		return logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(ts), LogStreamName: aws.String("web"), Message: aws.String(msg)}, logGroup: "group"}
+	}
+
+	a.NoError(fs.write(ev(1000, "lost")))
+	for _, file := range fs.files {
+		// e.g. the disk is gone: the buffered events can't be written
+		file.f.Close()
+	}
+	time.Sleep(5 * time.Millisecond)
+	a.Error(fs.flush())
+	a.Empty(fs.files, "the closed file is not kept")
+
+	a.NoError(fs.write(ev(2000, "written")))
+	a.NoError(fs.close())
+	names, err := filepath.Glob(filepath.Join(dir, "group", "web.*"))
+	a.NoError(err)
+	a.Len(names, 1)
+	b, err := os.ReadFile(names[0])
+	a.NoError(err)
+	a.Equal("written\n", string(b))
+}
+
+func TestFileSinkBoundsOpenFiles(t *testing.T) {
+	a := assert.New(t)
+	dir := t.TempDir()
+	l := log.New(io.Discard, "", log.LstdFlags)
+	color.NoColor = true
+	ev := func(stream string, ts int64, msg string) logEvent {
+		return logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(ts), LogStreamName: aws.String(stream), Message: aws.String(msg)}, logGroup: "group"}
+	}
+	open := func(s *fileSink) int {
+		n := 0
+		for _, file := range s.files {
+			if file.f != nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	s, err := newSink("file://"+dir, "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	fs := s.(*fileSink)
+	fs.maxOpen = 2
+	for _, msg := range []string{"first", "second"} {
+		for i := 0; i < 10; i++ {
+			a.NoError(fs.write(ev(fmt.Sprintf("stream-%d", i), 1000, msg)))
+			a.LessOrEqual(open(fs), 2)
+		}
+	}
+	a.NoError(fs.close())
+	for i := 0; i < 10; i++ {
+		names, err := filepath.Glob(filepath.Join(dir, "group", fmt.Sprintf("stream-%d.*", i)))
+		a.NoError(err)
+		a.Len(names, 1, "closed files are continued, and rotated on close")
+		b, err := os.ReadFile(names[0])
+		a.NoError(err)
+		a.Equal("first\nsecond\n", string(b))
+	}
+
+	// files older than max-age are rotated when written, not only when flushed by the checkpoint
+	dir = t.TempDir()
+	s, err = newSink("file://"+dir+"?max-age=1ms", "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	a.NoError(s.write(ev("web", 1000, "old")))
+	time.Sleep(5 * time.Millisecond)
+	a.NoError(s.write(ev("web", 2000, "new")))
+	names, err := filepath.Glob(filepath.Join(dir, "group", "web.*"))
+	a.NoError(err)
+	a.Equal([]string{"web.19700101T000001.000Z-19700101T000001.000Z.log", "web.19700101T000002.000Z.log"}, []string{filepath.Base(names[0]), filepath.Base(names[1])})
+	a.NoError(s.close())
+}
+
+func TestSyslogSink(t *testing.T) {
+	a := assert.New(t)
+	l := log.New(io.Discard, "", log.LstdFlags)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	a.NoError(err)
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	s, err := newSink("syslog+tcp://"+ln.Addr().String()+"?facility=local0&app=orders&batch-wait=10ms", "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	a.NoError(s.write(logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(0), LogStreamName: aws.String("web]1"), EventId: aws.String("1"), Message: aws.String(`{"level":"error","msg":"boom"}` + "\n")}, logGroup: "orders"}))
+	a.NoError(s.write(logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(1), LogStreamName: aws.String("web"), EventId: aws.String("2"), Message: aws.String("started")}, logGroup: "orders"}))
+	a.NoError(s.close())
+
+	hostname, _ := os.Hostname()
+	first := fmt.Sprintf(`<131>1 1970-01-01T00:00:00.000Z %s orders - - [cw@32473 group="orders" stream="web\]1" id="1"] {"level":"error","msg":"boom"}`, hostname)
+	second := fmt.Sprintf(`<134>1 1970-01-01T00:00:00.001Z %s orders - - [cw@32473 group="orders" stream="web" id="2"] started`, hostname)
+	select {
+	case got := <-received:
+		a.Equal(fmt.Sprintf("%d %s%d %s", len(first), first, len(second), second), got)
+	case <-time.After(time.Second):
+		a.Fail("no message received")
+	}
+}
+
+func TestHTTPSinks(t *testing.T) {
+	a := assert.New(t)
+	l := log.New(io.Discard, "", log.LstdFlags)
+	var mu sync.Mutex
+	var bodies []string
+	var paths []string
+	failures := 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		paths = append(paths, r.URL.String())
+	}))
+	defer server.Close()
+
+	ev := func(group string, ts int64, msg string) logEvent {
+		return logEvent{logEvent: types.FilteredLogEvent{Timestamp: aws.Int64(ts), LogStreamName: aws.String("web"), EventId: aws.String(fmt.Sprint(ts)), Message: aws.String(msg)}, logGroup: group}
+	}
+
+	s, err := newSink(server.URL+"/ingest?token=x&batch-size=2", "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	s.(*batchSink).retryDelay = time.Millisecond
+	for i := int64(1); i <= 3; i++ {
+		a.NoError(s.write(ev("orders", i, fmt.Sprintf(`{"n":%d}`, i))))
+	}
+	a.NoError(s.flush())
+	a.NoError(s.close())
+	mu.Lock()
+	a.Equal([]string{"/ingest?token=x", "/ingest?token=x"}, paths, "the sink options are not sent")
+	a.Equal(2, strings.Count(bodies[0], "\n"), "batches of batch-size events, the failed batch is retried")
+	a.Contains(bodies[1], `"message":{"n":3}`)
+	bodies, paths = nil, nil
+	mu.Unlock()
+
+	s, err = newSink(strings.Replace(server.URL, "http://", "loki://", 1), "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	a.NoError(s.write(ev("orders", 2, "second")))
+	a.NoError(s.write(ev("payments", 3, "payment")))
+	a.NoError(s.write(ev("orders", 1, "first")))
+	a.NoError(s.close())
+	mu.Lock()
+	a.Equal([]string{"/loki/api/v1/push"}, paths)
+	a.JSONEq(`{"streams":[
+		{"stream":{"job":"cw","log_group":"orders"},"values":[["1000000","first"],["2000000","second"]]},
+		{"stream":{"job":"cw","log_group":"payments"},"values":[["3000000","payment"]]}]}`, bodies[0])
+
+	failures = 100
+	mu.Unlock()
+
+	s, err = newSink(server.URL+"?retries=2", "text", logEventFormatter{Log: l}, l)
+	a.NoError(err)
+	s.(*batchSink).retryDelay = time.Millisecond
+	a.NoError(s.write(ev("orders", 1, "lost")))
+	a.Error(s.flush(), "failed batches are reported once retries are exhausted")
+	a.Error(s.write(ev("orders", 2, "lost")))
+	a.Error(s.close())
+}
+
+func TestParsePutCommandLine(t *testing.T) {
+	a := assert.New(t)
+	parser, err := kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"put", "g:s"})
+	a.NoError(err)
+	a.Equal("g:s", cli.Put.LogGroupStreamName)
+	a.Equal("", cli.Put.File)
+}
+
+func TestReadEvents(t *testing.T) {
+	a := assert.New(t)
+	at := func(layout, value string) time.Time {
+		tm, err := time.Parse(layout, value)
+		a.NoError(err)
+		return tm
+	}
+
+	tm, ok := lineTimestamp("2023-05-01T10:00:00.5Z GET /", "rfc3339", time.UTC)
+	a.True(ok)
+	a.Equal(at(time.RFC3339, "2023-05-01T10:00:00.5Z"), tm)
+	tm, ok = lineTimestamp("1682935200000 GET /", "epochms", time.UTC)
+	a.True(ok)
+	a.Equal(int64(1682935200000), tm.UnixNano()/int64(time.Millisecond))
+	tm, ok = lineTimestamp("1682935200.25 GET /", "epoch", time.UTC)
+	a.True(ok)
+	a.Equal(int64(1682935200250), tm.UnixNano()/int64(time.Millisecond))
+	_, ok = lineTimestamp("GET /", "epoch", time.UTC)
+	a.False(ok)
+
+	input := "2023-05-01 10:00:00 ERROR boom\n\tat main.go:10\n\n2023-05-01 09:59:00 INFO started\r\n"
+	events := make(chan types.InputLogEvent, 10)
+	a.NoError(readEvents(context.Background(), strings.NewReader(input), "2006-01-02 15:04:05", time.UTC, events))
+	var got []string
+	for ev := range events {
+		got = append(got, fmt.Sprintf("%s %d", *ev.Message, *ev.Timestamp))
+	}
+	a.Equal([]string{
+		"2023-05-01 10:00:00 ERROR boom 1682935200000",
+		"\tat main.go:10 1682935200000",
+		"2023-05-01 09:59:00 INFO started 1682935140000",
+	}, got, "lines without a timestamp take the one of the previous line")
+}
+
+func TestGroupCommandHelpers(t *testing.T) {
+	a := assert.New(t)
+
+	days, err := parseRetention("30")
+	a.NoError(err)
+	a.Equal(int32(30), days)
+	days, err = parseRetention("never")
+	a.NoError(err)
+	a.Equal(int32(0), days)
+	_, err = parseRetention("31")
+	a.Error(err)
+
+	tags, err := parseTags([]string{"team=payments", "note=a=b"})
+	a.NoError(err)
+	a.Equal(map[string]string{"team": "payments", "note": "a=b"}, tags)
+	_, err = parseTags([]string{"team"})
+	a.Error(err)
+
+	var out bytes.Buffer
+	a.True(confirm(strings.NewReader("y\n"), &out, "Delete?"))
+	a.Equal("Delete? [y/N] ", out.String())
+	a.True(confirm(strings.NewReader("YES\n"), &out, "Delete?"))
+	a.False(confirm(strings.NewReader("\n"), &out, "Delete?"))
+	a.False(confirm(strings.NewReader(""), &out, "Delete?"))
+
+	a.Equal("Delete log group /a?", groupsQuestion("Delete", []string{"/a"}))
+	a.Equal("Delete 3 log groups (/a ... /c)?", groupsQuestion("Delete", []string{"/a", "/b", "/c"}))
+}
+
+func TestConfirmReadsStdinUnlessGroupsWerePiped(t *testing.T) {
+	a := assert.New(t)
+	defer func(stdin *os.File) { os.Stdin, stdinConsumed = stdin, false }(os.Stdin)
+	pipe := func(input string) {
+		r, w, err := os.Pipe()
+		a.NoError(err)
+		_, err = w.WriteString(input)
+		a.NoError(err)
+		w.Close()
+		os.Stdin = r
+	}
+
+	pipe("y\n")
+	a.NoError(confirmOnTerminal("Delete?"), "nothing was piped: the answer is read from stdin")
+	pipe("n\n")
+	a.Error(confirmOnTerminal("Delete?"))
+
+	pipe("/a /b\n")
+	a.Equal([]string{"/a", "/b"}, fromStdin())
+	a.True(stdinConsumed, "the answer has to be read from the terminal")
+}
+
+func TestPatternMatches(t *testing.T) {
+	a := assert.New(t)
+	color.NoColor = true
+
+	lines, err := readSampleLines(strings.NewReader("GET /a 200\r\n\nGET /b 500\n"))
+	a.NoError(err)
+	a.Equal([]sampleLine{{Number: 1, Text: "GET /a 200"}, {Number: 3, Text: "GET /b 500"}}, lines)
+
+	matches := toPatternMatches(lines, []types.MetricFilterMatchRecord{
+		{EventNumber: 2, EventMessage: aws.String("GET /b 500"), ExtractedValues: map[string]string{"$status": "500", "$path": "/b"}},
+	})
+	a.False(matches[0].Matched)
+	a.True(matches[1].Matched)
+
+	var b bytes.Buffer
+	a.NoError(writePatternMatches(&b, "text", false, matches))
+	a.Equal("  1: GET /a 200\n+ 3: GET /b 500\n      $path = /b\n      $status = 500\n", b.String(), "lines keep their number in the input")
+
+	b.Reset()
+	a.NoError(writePatternMatches(&b, "json", true, matches))
+	a.JSONEq(`[{"line":3,"message":"GET /b 500","matched":true,"values":{"$path":"/b","$status":"500"}}]`, b.String())
+
+	b.Reset()
+	a.NoError(writePatternMatches(&b, "json", true, matches[:1]))
+	a.JSONEq(`[]`, b.String())
+}
+
+func TestTailRejectsInvalidGrepv(t *testing.T) {
+	cmd := tailCmd{LogGroupStreamName: []string{"group"}, StartTime: "1h", Grepv: "["}
+	err := cmd.Run(&appContext{Context: context.Background(), DebugLog: log.New(io.Discard, "", log.LstdFlags)})
+	assert.ErrorContains(t, err, "invalid --grepv pattern")
+}
+
+func TestJSONOutputIsAnArray(t *testing.T) {
+	a := assert.New(t)
+	parser, err := kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"tail", "g", "-o", "json"})
+	a.NoError(err)
+
+	formatter := logEventFormatter{Log: log.New(io.Discard, "", log.LstdFlags)}
+	ev := func(id string) logEvent {
+		return logEvent{logGroup: "group", logEvent: types.FilteredLogEvent{EventId: aws.String(id), Timestamp: aws.Int64(0), Message: aws.String(`{"id":` + id + `}`)}}
+	}
+	var b strings.Builder
+	w := newEventWriter(&b, "json", formatter)
+	a.NoError(w.write(ev("1")))
+	a.NoError(w.write(ev("2")))
+	a.NoError(w.close())
+	var records []map[string]interface{}
+	a.NoError(json.Unmarshal([]byte(b.String()), &records))
+	a.Len(records, 2)
+	a.Equal("2", records[1]["eventId"])
+	a.Equal(map[string]interface{}{"id": 1.0}, records[0]["message"])
+
+	b.Reset()
+	a.NoError(newEventWriter(&b, "json", formatter).close())
+	a.JSONEq(`[]`, b.String(), "no events is an empty array")
+
+	b.Reset()
+	w = newEventWriter(&b, "ndjson", formatter)
+	a.NoError(w.write(ev("1")))
+	a.NoError(w.write(ev("2")))
+	a.NoError(w.close())
+	a.Equal(2, strings.Count(b.String(), "\n"), "ndjson is an object per line")
+
+	// a file continued by a later run is still a single array once rotated
+	dir := t.TempDir()
+	for _, id := range []string{"1", "2"} {
+		s, err := newSink("file://"+dir, "json", formatter, formatter.Log)
+		a.NoError(err)
+		a.NoError(s.write(ev(id)))
+		a.NoError(s.flush())
+	}
+	s, err := newSink("file://"+dir, "json", formatter, formatter.Log)
+	a.NoError(err)
+	a.NoError(s.write(ev("3")))
+	a.NoError(s.close())
+	names, err := filepath.Glob(filepath.Join(dir, "group", "*.json"))
+	a.NoError(err)
+	a.Len(names, 1)
+	content, err := os.ReadFile(names[0])
+	a.NoError(err)
+	records = nil
+	a.NoError(json.Unmarshal(content, &records))
+	a.Len(records, 3)
+}
+
+func TestLsStreamsCannotSortBySize(t *testing.T) {
+	a := assert.New(t)
+	parser, err := kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"ls", "streams", "g", "--sort", "size"})
+	a.Error(err, "the stored bytes of a stream are always 0")
+	_, err = parser.Parse([]string{"ls", "streams", "g", "--sort", "last-event"})
+	a.NoError(err)
+}
+
+func TestParseLsGroupsCommandLine(t *testing.T) {
+	a := assert.New(t)
+	parser, err := kong.New(&cli, kongOptions()...)
+	a.NoError(err)
+	_, err = parser.Parse([]string{"ls", "groups", "--sort", "size"})
+	a.NoError(err)
+	_, err = parser.Parse([]string{"ls", "groups", "--prefix", "/aws/"})
+	a.NoError(err)
+	_, err = parser.Parse([]string{"ls", "groups", "--prefix", "/aws/", "--pattern", "orders"})
+	a.Error(err)
 }
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+type metricFilterCmd struct {
+	Ls   metricFilterLsCmd   `cmd:"" help:"Show the metric filters."`
+	Put  metricFilterPutCmd  `cmd:"" help:"Create or replace a metric filter."`
+	Rm   metricFilterRmCmd   `cmd:"" help:"Delete a metric filter."`
+	Test metricFilterTestCmd `cmd:"" help:"Test a filter pattern against sample lines read from stdin, or a file, showing which lines match and the extracted values."`
+}
+
+func metricFiltersManager(ctx *appContext) *cloudwatch.MetricFilters {
+	return &cloudwatch.MetricFilters{Client: &ctx.Client, Logger: ctx.DebugLog}
+}
+
+type metricFilterLsCmd struct {
+	LogGroupName string `arg:"" name:"groupName" optional:"" help:"Only show the metric filters of the given log group."`
+	Prefix       string `name:"prefix" help:"Only show the filters whose name starts with the given prefix. Requires groupName." default:""`
+	Output       string `name:"output" help:"The output format: text or json." short:"o" enum:"text,json" default:"text"`
+}
+
+type metricFilterRecord struct {
+	LogGroupName string            `json:"logGroupName"`
+	Name         string            `json:"name"`
+	Pattern      string            `json:"pattern"`
+	Namespace    string            `json:"namespace"`
+	MetricName   string            `json:"metricName"`
+	Value        string            `json:"value"`
+	DefaultValue *float64          `json:"defaultValue,omitempty"`
+	Unit         string            `json:"unit,omitempty"`
+	Dimensions   map[string]string `json:"dimensions,omitempty"`
+	CreationTime string            `json:"creationTime"`
+}
+
+func toMetricFilterRecord(f types.MetricFilter) metricFilterRecord {
+	r := metricFilterRecord{
+		LogGroupName: derefString(f.LogGroupName),
+		Name:         derefString(f.FilterName),
+		Pattern:      derefString(f.FilterPattern),
+		CreationTime: formatMillisTime(f.CreationTime),
+	}
+	if len(f.MetricTransformations) > 0 {
+		t := f.MetricTransformations[0]
+		r.Namespace = derefString(t.MetricNamespace)
+		r.MetricName = derefString(t.MetricName)
+		r.Value = derefString(t.MetricValue)
+		r.DefaultValue = t.DefaultValue
+		r.Unit = string(t.Unit)
+		r.Dimensions = t.Dimensions
+	}
+	return r
+}
+
+func writeMetricFilters(w io.Writer, format string, filters []types.MetricFilter) error {
+	if format == "json" {
+		records := make([]metricFilterRecord, 0, len(filters))
+		for _, f := range filters {
+			records = append(records, toMetricFilterRecord(f))
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tNAME\tMETRIC\tVALUE\tPATTERN")
+	for _, f := range filters {
+		r := toMetricFilterRecord(f)
+		fmt.Fprintf(tw, "%s\t%s\t%s/%s\t%s\t%s\n", r.LogGroupName, r.Name, r.Namespace, r.MetricName, r.Value, r.Pattern)
+	}
+	return tw.Flush()
+}
+
+func (l *metricFilterLsCmd) Run(ctx *appContext) error {
+	if l.Prefix != "" && l.LogGroupName == "" {
+		return fmt.Errorf("--prefix requires a log group")
+	}
+	found, errCh := cloudwatch.LsMetricFilters(ctx.Context, &ctx.Client, &l.LogGroupName, &l.Prefix)
+	var filters []types.MetricFilter
+	for f := range found {
+		filters = append(filters, f)
+	}
+	if err := <-errCh; err != nil {
+		return err
+	}
+	return writeMetricFilters(os.Stdout, l.Output, filters)
+}
+
+type metricFilterPutCmd struct {
+	LogGroupName string   `arg:"" name:"groupName" help:"The log group of the filter."`
+	FilterName   string   `arg:"" name:"filterName" help:"The name of the filter. An existing filter with the same name is replaced."`
+	Pattern      string   `name:"pattern" help:"The filter pattern. See http://docs.aws.amazon.com/AmazonCloudWatch/latest/logs/FilterAndPatternSyntax.html for syntax. Try it first with cw metric-filter test." short:"p" required:""`
+	Namespace    string   `name:"namespace" help:"The namespace of the metric." required:""`
+	MetricName   string   `name:"metric-name" help:"The name of the metric." required:""`
+	Value        string   `name:"value" help:"The value published for every matching event: a number or an extracted field, e.g. $latency." default:"1"`
+	DefaultValue string   `name:"default-value" help:"The value published when no event matches. By default nothing is published." placeholder:"NUMBER" default:""`
+	Unit         string   `name:"unit" help:"The unit of the metric, e.g. Milliseconds or Count." default:""`
+	Dimensions   []string `name:"dimension" help:"A dimension of the metric, whose value is an extracted field, e.g. status=$status. Can be repeated." placeholder:"KEY=$FIELD"`
+	DryRun       bool     `name:"dry-run" help:"Show the filter without creating it." default:"false"`
+}
+
+func (p *metricFilterPutCmd) Run(ctx *appContext) error {
+	transformation := types.MetricTransformation{
+		MetricNamespace: &p.Namespace,
+		MetricName:      &p.MetricName,
+		MetricValue:     &p.Value,
+		Unit:            types.StandardUnit(p.Unit),
+	}
+	if p.DefaultValue != "" {
+		v, err := strconv.ParseFloat(p.DefaultValue, 64)
+		if err != nil {
+			return fmt.Errorf("invalid default value %s: %w", p.DefaultValue, err)
+		}
+		transformation.DefaultValue = &v
+	}
+	if len(p.Dimensions) > 0 {
+		dimensions, err := parseTags(p.Dimensions)
+		if err != nil {
+			return err
+		}
+		transformation.Dimensions = dimensions
+	}
+	filter := &cloudwatchlogs.PutMetricFilterInput{
+		LogGroupName:          &p.LogGroupName,
+		FilterName:            &p.FilterName,
+		FilterPattern:         &p.Pattern,
+		MetricTransformations: []types.MetricTransformation{transformation},
+	}
+	if p.DryRun {
+		fmt.Println("would put (dry run):")
+		return writeMetricFilters(os.Stdout, "text", []types.MetricFilter{{
+			LogGroupName:          filter.LogGroupName,
+			FilterName:            filter.FilterName,
+			FilterPattern:         filter.FilterPattern,
+			MetricTransformations: filter.MetricTransformations,
+		}})
+	}
+	if err := metricFiltersManager(ctx).Put(ctx.Context, filter); err != nil {
+		return err
+	}
+	fmt.Printf("%s: metric filter %s put\n", p.LogGroupName, p.FilterName)
+	return nil
+}
+
+type metricFilterRmCmd struct {
+	LogGroupName string `arg:"" name:"groupName" help:"The log group of the filter."`
+	FilterName   string `arg:"" name:"filterName" help:"The name of the filter."`
+	Yes          bool   `name:"yes" help:"Don't ask for confirmation." short:"y" default:"false"`
+	DryRun       bool   `name:"dry-run" help:"Show what would be done without changing anything." default:"false"`
+}
+
+func (r *metricFilterRmCmd) Run(ctx *appContext) error {
+	if r.DryRun {
+		fmt.Printf("%s: would delete metric filter %s (dry run)\n", r.LogGroupName, r.FilterName)
+		return nil
+	}
+	if !r.Yes {
+		if err := confirmOnTerminal(fmt.Sprintf("Delete metric filter %s of log group %s?", r.FilterName, r.LogGroupName)); err != nil {
+			return err
+		}
+	}
+	if err := metricFiltersManager(ctx).Delete(ctx.Context, r.LogGroupName, r.FilterName); err != nil {
+		return err
+	}
+	fmt.Printf("%s: metric filter %s deleted\n", r.LogGroupName, r.FilterName)
+	return nil
+}
+
+type metricFilterTestCmd struct {
+	Pattern      string `name:"pattern" help:"The filter pattern to test." short:"p" xor:"pattern"`
+	Filter       string `name:"filter" help:"Test the pattern of an existing metric filter." placeholder:"GROUP:FILTER" xor:"pattern"`
+	File         string `name:"file" help:"Read the sample lines from the given file rather than from stdin." short:"f" type:"existingfile" placeholder:"FILE"`
+	OnlyMatching bool   `name:"only-matching" help:"Only show the matching lines." short:"m" default:"false"`
+	Output       string `name:"output" help:"The output format: text or json." short:"o" enum:"text,json" default:"text"`
+}
+
+// patternMatch is the outcome of testing a filter pattern against a sample line
+type patternMatch struct {
+	Line    int               `json:"line"`
+	Message string            `json:"message"`
+	Matched bool              `json:"matched"`
+	Values  map[string]string `json:"values,omitempty"`
+}
+
+// sampleLine is a non empty line of the sample, with its number in the input
+type sampleLine struct {
+	Number int
+	Text   string
+}
+
+// readSampleLines returns the non empty lines of r
+func readSampleLines(r io.Reader) ([]sampleLine, error) {
+	var lines []sampleLine
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*cloudwatch.MaxEventSize)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, sampleLine{Number: n, Text: line})
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// toPatternMatches pairs every line with its match record, if any.
+// Records are numbered after the lines sent to CloudWatch, i.e. without the empty ones.
+func toPatternMatches(lines []sampleLine, records []types.MetricFilterMatchRecord) []patternMatch {
+	matches := make([]patternMatch, len(lines))
+	for i, line := range lines {
+		matches[i] = patternMatch{Line: line.Number, Message: line.Text}
+	}
+	for _, r := range records {
+		if i := int(r.EventNumber) - 1; i >= 0 && i < len(matches) {
+			matches[i].Matched = true
+			matches[i].Values = r.ExtractedValues
+		}
+	}
+	return matches
+}
+
+func writePatternMatches(w io.Writer, format string, onlyMatching bool, matches []patternMatch) error {
+	var shown []patternMatch
+	for _, m := range matches {
+		if m.Matched || !onlyMatching {
+			shown = append(shown, m)
+		}
+	}
+	if format == "json" {
+		if shown == nil {
+			shown = []patternMatch{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(shown)
+	}
+	for _, m := range shown {
+		if !m.Matched {
+			fmt.Fprintf(w, "  %d: %s\n", m.Line, m.Message)
+			continue
+		}
+		fmt.Fprintln(w, color.GreenString("+ %d: %s", m.Line, m.Message))
+		keys := make([]string, 0, len(m.Values))
+		for k := range m.Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "      %s = %s\n", color.CyanString(k), m.Values[k])
+		}
+	}
+	return nil
+}
+
+// filterPattern returns the pattern of the metric filter GROUP:FILTER
+func filterPattern(ctx *appContext, filter string) (string, error) {
+	parts := strings.SplitN(filter, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", fmt.Errorf("expected groupName:filterName, got %s", filter)
+	}
+	found, errCh := cloudwatch.LsMetricFilters(ctx.Context, &ctx.Client, &parts[0], &parts[1])
+	pattern, ok := "", false
+	for f := range found {
+		if derefString(f.FilterName) == parts[1] {
+			pattern, ok = derefString(f.FilterPattern), true
+		}
+	}
+	if err := <-errCh; err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no metric filter %s in log group %s", parts[1], parts[0])
+	}
+	return pattern, nil
+}
+
+func (t *metricFilterTestCmd) Run(ctx *appContext) error {
+	if t.Pattern == "" && t.Filter == "" {
+		fmt.Fprintln(os.Stderr, "cw: error: one of --pattern or --filter must be provided, try --help")
+		os.Exit(1)
+	}
+	pattern := t.Pattern
+	if t.Filter != "" {
+		var err error
+		if pattern, err = filterPattern(ctx, t.Filter); err != nil {
+			return err
+		}
+	}
+
+	var in io.Reader = os.Stdin
+	if t.File != "" {
+		f, err := os.Open(t.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	lines, err := readSampleLines(in)
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return fmt.Errorf("no sample lines to test the pattern against")
+	}
+
+	messages := make([]string, len(lines))
+	for i, line := range lines {
+		messages[i] = line.Text
+	}
+	records, err := metricFiltersManager(ctx).Test(ctx.Context, pattern, messages)
+	if err != nil {
+		var invalid *types.InvalidParameterException
+		if errors.As(err, &invalid) {
+			return fmt.Errorf("invalid pattern %s: %s", pattern, aws.ToString(invalid.Message))
+		}
+		return err
+	}
+	if err := writePatternMatches(os.Stdout, t.Output, t.OnlyMatching, toPatternMatches(lines, records)); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "cw: %d of %d lines match %s\n", len(records), len(lines), pattern)
+	return nil
+}
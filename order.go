@@ -0,0 +1,105 @@
+package main
+
+import (
+	"container/heap"
+	"time"
+)
+
+type pendingEvent struct {
+	ev      *logEvent
+	arrival time.Time
+	seq     int
+	emitted bool
+}
+
+func eventTimestamp(ev *logEvent) int64 {
+	if ev.logEvent.Timestamp == nil {
+		return 0
+	}
+	return *ev.logEvent.Timestamp
+}
+
+// eventHeap is a min heap of events by timestamp, in arrival order for the same timestamp
+type eventHeap []*pendingEvent
+
+func (h eventHeap) Len() int { return len(h) }
+func (h eventHeap) Less(i, j int) bool {
+	ti, tj := eventTimestamp(h[i].ev), eventTimestamp(h[j].ev)
+	if ti != tj {
+		return ti < tj
+	}
+	return h[i].seq < h[j].seq
+}
+func (h eventHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *eventHeap) Push(x interface{}) { *h = append(*h, x.(*pendingEvent)) }
+func (h *eventHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
+
+// orderEvents is the pipeline stage merging the events of all the tailed groups in timestamp order.
+// Every event is held for delay after its arrival. Once its delay is over the event is emitted, together
+// with all the held events older than it: the events arriving up to delay late are then put back in order.
+// The events still held are flushed, in order, when in is closed.
+func orderEvents(in <-chan *logEvent, delay time.Duration) <-chan *logEvent {
+	out := make(chan *logEvent)
+	go func() {
+		defer close(out)
+		var byTimestamp eventHeap
+		// byArrival is the queue of the events in arrival order, the first one is the next to expire
+		var byArrival []*pendingEvent
+		seq := 0
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+
+		emitUpTo := func(watermark int64) {
+			for len(byTimestamp) > 0 && eventTimestamp(byTimestamp[0].ev) <= watermark {
+				p := heap.Pop(&byTimestamp).(*pendingEvent)
+				p.emitted = true
+				out <- p.ev
+			}
+		}
+
+		for {
+			for len(byArrival) > 0 && byArrival[0].emitted {
+				byArrival = byArrival[1:]
+			}
+			var wake <-chan time.Time
+			if len(byArrival) > 0 {
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(time.Until(byArrival[0].arrival.Add(delay)))
+				wake = timer.C
+			}
+
+			select {
+			case ev, ok := <-in:
+				if !ok {
+					for len(byTimestamp) > 0 {
+						out <- heap.Pop(&byTimestamp).(*pendingEvent).ev
+					}
+					return
+				}
+				seq++
+				p := &pendingEvent{ev: ev, arrival: time.Now(), seq: seq}
+				heap.Push(&byTimestamp, p)
+				byArrival = append(byArrival, p)
+			case now := <-wake:
+				for len(byArrival) > 0 && !now.Before(byArrival[0].arrival.Add(delay)) {
+					if p := byArrival[0]; !p.emitted {
+						emitUpTo(eventTimestamp(p.ev))
+					}
+					byArrival = byArrival[1:]
+				}
+			}
+		}
+	}()
+	return out
+}
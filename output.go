@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const recordTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// eventRecord is the structured representation of a log event used by the non-text output modes.
+type eventRecord struct {
+	Timestamp     string          `json:"timestamp"`
+	IngestionTime string          `json:"ingestionTime"`
+	Group         string          `json:"group"`
+	Stream        string          `json:"stream"`
+	EventID       string          `json:"eventId"`
+	Message       json.RawMessage `json:"message"`
+
+	rawMessage string
+}
+
+func millisToTime(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+func formatMillis(ms *int64) string {
+	if ms == nil {
+		return ""
+	}
+	return millisToTime(*ms).UTC().Format(recordTimeFormat)
+}
+
+func derefString(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (f logEventFormatter) toRecord(ev logEvent) eventRecord {
+	msg := derefString(ev.logEvent.Message)
+	if f.FormatConfig.Query != nil {
+		msg = f.jmespathQuery(msg, *f.FormatConfig.Query)
+	}
+
+	r := eventRecord{
+		Timestamp:     formatMillis(ev.logEvent.Timestamp),
+		IngestionTime: formatMillis(ev.logEvent.IngestionTime),
+		Group:         ev.logGroup,
+		Stream:        derefString(ev.logEvent.LogStreamName),
+		EventID:       derefString(ev.logEvent.EventId),
+		rawMessage:    msg,
+	}
+	// messages that are valid JSON objects or arrays are embedded as they are, everything else is a JSON string
+	trimmed := strings.TrimSpace(msg)
+	if (strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[")) && json.Valid([]byte(trimmed)) {
+		r.Message = json.RawMessage(trimmed)
+	} else {
+		encoded, _ := json.Marshal(msg)
+		r.Message = encoded
+	}
+	return r
+}
+
+type eventWriter interface {
+	write(ev logEvent) error
+	// close completes the output, it does not close the underlying writer
+	close() error
+}
+
+func newEventWriter(w io.Writer, output string, formatter logEventFormatter) eventWriter {
+	switch output {
+	case "json":
+		return &jsonArrayEventWriter{w: w, formatter: formatter}
+	case "ndjson":
+		return &jsonEventWriter{enc: json.NewEncoder(w), formatter: formatter}
+	case "logfmt":
+		return &logfmtEventWriter{w: w, formatter: formatter}
+	case "csv":
+		return &csvEventWriter{w: csv.NewWriter(w), formatter: formatter}
+	default:
+		return &textEventWriter{w: w, formatter: formatter}
+	}
+}
+
+type textEventWriter struct {
+	w         io.Writer
+	formatter logEventFormatter
+}
+
+func (t *textEventWriter) write(ev logEvent) error {
+	_, err := fmt.Fprintln(t.w, t.formatter.formatLogMsg(ev))
+	return err
+}
+
+func (t *textEventWriter) close() error { return nil }
+
+type jsonEventWriter struct {
+	enc       *json.Encoder
+	formatter logEventFormatter
+}
+
+func (j *jsonEventWriter) write(ev logEvent) error {
+	return j.enc.Encode(j.formatter.toRecord(ev))
+}
+
+func (j *jsonEventWriter) close() error { return nil }
+
+// jsonArrayEventWriter writes the events as a single JSON array: it is a valid document once closed
+type jsonArrayEventWriter struct {
+	w         io.Writer
+	formatter logEventFormatter
+	// started is true once the opening bracket has been written
+	started bool
+}
+
+func (j *jsonArrayEventWriter) write(ev logEvent) error {
+	b, err := json.Marshal(j.formatter.toRecord(ev))
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if !j.started {
+		sep = "[\n"
+		j.started = true
+	}
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, b)
+	return err
+}
+
+func (j *jsonArrayEventWriter) close() error {
+	end := "\n]\n"
+	if !j.started {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
+
+type logfmtEventWriter struct {
+	w         io.Writer
+	formatter logEventFormatter
+}
+
+func logfmtValue(v string) string {
+	if v == "" {
+		return `""`
+	}
+	if strings.ContainsAny(v, " =\"\t\r\n") {
+		return fmt.Sprintf("%q", v)
+	}
+	return v
+}
+
+func (l *logfmtEventWriter) write(ev logEvent) error {
+	r := l.formatter.toRecord(ev)
+	_, err := fmt.Fprintf(l.w, "timestamp=%s ingestion_time=%s group=%s stream=%s event_id=%s message=%s\n",
+		logfmtValue(r.Timestamp), logfmtValue(r.IngestionTime), logfmtValue(r.Group),
+		logfmtValue(r.Stream), logfmtValue(r.EventID), logfmtValue(r.rawMessage))
+	return err
+}
+
+func (l *logfmtEventWriter) close() error { return nil }
+
+type csvEventWriter struct {
+	w             *csv.Writer
+	formatter     logEventFormatter
+	headerWritten bool
+}
+
+func (c *csvEventWriter) write(ev logEvent) error {
+	if !c.headerWritten {
+		if err := c.w.Write([]string{"timestamp", "ingestion_time", "group", "stream", "event_id", "message"}); err != nil {
+			return err
+		}
+		c.headerWritten = true
+	}
+	r := c.formatter.toRecord(ev)
+	if err := c.w.Write([]string{r.Timestamp, r.IngestionTime, r.Group, r.Stream, r.EventID, r.rawMessage}); err != nil {
+		return err
+	}
+	// flush on every event, tailing is interactive and lines must not be held back
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvEventWriter) close() error { return nil }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+	"github.com/fatih/color"
+	"github.com/lucagrulla/cw/cloudwatch"
+)
+
+type putCmd struct {
+	LogGroupStreamName string `arg:"" name:"groupName:logStreamName" help:"The log group and the log stream to write the events to."`
+	File               string `name:"file" help:"Read the lines from the given file rather than from stdin." short:"f" type:"existingfile" placeholder:"FILE"`
+	Create             bool   `name:"create" help:"Create the log group and the log stream if they don't exist." short:"c" default:"false"`
+	TimeFormat         string `name:"time-format" help:"Take the timestamp of every event from the start of its line, in the given format: rfc3339, epochms, epoch or a Go time layout e.g. '2006-01-02 15:04:05'. Lines without a timestamp, e.g. stack traces, take the one of the previous line. By default events are timestamped with the time they are read." placeholder:"FORMAT" default:""`
+	Local              bool   `name:"local" help:"Treat the timestamps without a time zone in Local timezone." short:"l" default:"false"`
+	TZ                 string `name:"tz" help:"The IANA time zone, e.g. Europe/London, of the timestamps without a time zone. Overrides --local." placeholder:"ZONE" default:""`
+}
+
+// lineTimestamp parses the timestamp at the start of line in the given format
+func lineTimestamp(line string, format string, zone *time.Location) (time.Time, bool) {
+	fields := strings.Fields(line)
+	n := 1
+	if format != "rfc3339" && format != "epoch" && format != "epochms" {
+		n = len(strings.Fields(format))
+	}
+	if n == 0 || len(fields) < n {
+		return time.Time{}, false
+	}
+	value := strings.Join(fields[:n], " ")
+	switch format {
+	case "rfc3339":
+		t, err := time.Parse(time.RFC3339Nano, value)
+		return t, err == nil
+	case "epochms":
+		ms, err := strconv.ParseInt(value, 10, 64)
+		return millisToTime(ms), err == nil
+	case "epoch":
+		s, err := strconv.ParseFloat(value, 64)
+		// CloudWatch keeps milliseconds, rounding avoids the float error
+		return millisToTime(int64(math.Round(s * 1000))), err == nil
+	default:
+		t, err := time.ParseInLocation(format, value, zone)
+		return t, err == nil
+	}
+}
+
+// readEvents sends an event for every non empty line of r
+func readEvents(ctx context.Context, r io.Reader, format string, zone *time.Location, events chan<- types.InputLogEvent) error {
+	defer close(events)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*cloudwatch.MaxEventSize)
+	var last time.Time
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		ts := time.Now()
+		if format != "" {
+			if t, ok := lineTimestamp(line, format, zone); ok {
+				last = t
+			}
+			if !last.IsZero() {
+				ts = last
+			}
+		}
+		select {
+		case events <- types.InputLogEvent{Message: aws.String(line), Timestamp: aws.Int64(ts.UnixNano() / int64(time.Millisecond))}:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *putCmd) Run(ctx *appContext) error {
+	parts := strings.SplitN(p.LogGroupStreamName, ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected groupName:logStreamName, got %s", p.LogGroupStreamName)
+	}
+	group, stream := parts[0], parts[1]
+	zone, err := timeZone(p.TZ, p.Local)
+	if err != nil {
+		return fmt.Errorf("unknown time zone %s: %w", p.TZ, err)
+	}
+
+	var in io.Reader = os.Stdin
+	if p.File != "" {
+		f, err := os.Open(p.File)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	events := make(chan types.InputLogEvent, 1000)
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- readEvents(ctx.Context, in, p.TimeFormat, zone, events)
+	}()
+
+	stats, err := cloudwatch.Put(ctx.Context, &ctx.Client, cloudwatch.PutConfig{
+		LogGroupName:  &group,
+		LogStreamName: &stream,
+		Create:        p.Create,
+		Warn: func(msg string) {
+			fmt.Fprintln(os.Stderr, color.YellowString("cw: "+msg))
+		},
+	}, events, ctx.DebugLog)
+	fmt.Fprintf(os.Stderr, "cw: %d events put to %s in %d batches\n", stats.Events, p.LogGroupStreamName, stats.Batches)
+	if err != nil {
+		return err
+	}
+	return <-readErr
+}
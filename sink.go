@@ -0,0 +1,429 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sink is the destination of the tailed events, selected with --sink. Without it events are written to stdout.
+type sink interface {
+	write(ev logEvent) error
+	// flush makes the events written so far durable, it is called before every checkpoint is saved
+	flush() error
+	close() error
+}
+
+// newSink creates the sink for the --sink URI
+func newSink(uri string, output string, formatter logEventFormatter, log *log.Logger) (sink, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sink %s: %w", uri, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return newFileSink(u, output, formatter, log)
+	case "syslog", "syslog+udp", "syslog+tcp":
+		return newBatchSink(u, newSyslogSender, log)
+	case "http", "https":
+		return newBatchSink(u, func(u *url.URL) (batchSender, error) {
+			return &httpSender{url: u.String(), client: &http.Client{Timeout: forwardTimeout}, formatter: formatter}, nil
+		}, log)
+	case "loki", "loki+http", "loki+https":
+		return newBatchSink(u, func(u *url.URL) (batchSender, error) {
+			return newLokiSender(u, formatter)
+		}, log)
+	default:
+		return nil, fmt.Errorf("unsupported sink %s, the supported sinks are: file://DIR, syslog[+tcp|+udp]://HOST[:PORT], http[s]://URL and loki[+https]://HOST[:PORT]", uri)
+	}
+}
+
+// writerSink writes the events to stdout
+type writerSink struct {
+	writer eventWriter
+}
+
+func (w *writerSink) write(ev logEvent) error { return w.writer.write(ev) }
+func (w *writerSink) flush() error            { return nil }
+func (w *writerSink) close() error            { return w.writer.close() }
+
+const (
+	sinkCheckpointFile = ".cw-checkpoint.json"
+	sinkTimeFormat     = "20060102T150405.000Z"
+	defaultSinkMaxSize = 100 * 1024 * 1024
+	defaultSinkMaxAge  = time.Hour
+	// sinkMaxOpenFiles bounds the files open at once, a group can have many more streams than the process can open files
+	sinkMaxOpenFiles = 64
+)
+
+var sinkFileExtensions = map[string]string{
+	"text":   ".log",
+	"json":   ".json",
+	"ndjson": ".ndjson",
+	"logfmt": ".log",
+	"csv":    ".csv",
+}
+
+// activeSinkFile matches the time range part of the name of a file still being written, e.g. 20230501T100000.000Z
+var activeSinkFile = regexp.MustCompile(`^\d{8}T\d{6}\.\d{3}Z$`)
+
+// fileSink writes the events to a file per stream within a directory per group.
+// The file being written is named after the timestamp of its first event, e.g. web-1.20230501T100000.000Z.log.
+// When it is larger than maxSize or older than maxAge it is renamed after the timestamps of its first and
+// last events, e.g. web-1.20230501T100000.000Z-20230501T105959.999Z.log, optionally gzip-compressed.
+type fileSink struct {
+	dir       string
+	maxSize   int64
+	maxAge    time.Duration
+	compress  bool
+	output    string
+	formatter logEventFormatter
+	log       *log.Logger
+	// maxOpen is the number of files kept open: past it the least recently written one is closed until its next event
+	maxOpen int
+
+	sync.Mutex
+	files map[string]*sinkFile
+}
+
+type sinkFile struct {
+	// base is the path of the file without the time range and the extension
+	base string
+	path string
+	// f is nil while the file is closed to keep the files open under maxOpen
+	f      *os.File
+	buf    *bufio.Writer
+	writer eventWriter
+	size   int64
+	opened time.Time
+	// written is when an event was last written to the file
+	written time.Time
+	first   int64
+	last    int64
+}
+
+func (s *sinkFile) Write(b []byte) (int, error) {
+	n, err := s.buf.Write(b)
+	s.size += int64(n)
+	return n, err
+}
+
+// parseSize parses a size in bytes with an optional unit: KB, MB or GB, e.g. 100MB
+func parseSize(v string) (int64, error) {
+	units := []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	s := strings.ToUpper(strings.TrimSpace(v))
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s, mult = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %s", v)
+	}
+	return n * mult, nil
+}
+
+// newFileSink creates the sink for file://DIR?max-size=100MB&max-age=1h&compress=gzip
+func newFileSink(u *url.URL, output string, formatter logEventFormatter, log *log.Logger) (*fileSink, error) {
+	s := &fileSink{
+		dir:       u.Host + u.Path,
+		maxSize:   defaultSinkMaxSize,
+		maxAge:    defaultSinkMaxAge,
+		output:    output,
+		formatter: formatter,
+		log:       log,
+		maxOpen:   sinkMaxOpenFiles,
+		files:     make(map[string]*sinkFile),
+	}
+	if s.dir == "" {
+		return nil, fmt.Errorf("the file sink needs a directory, e.g. file://./logs")
+	}
+	q := u.Query()
+	if v := q.Get("max-size"); v != "" {
+		size, err := parseSize(v)
+		if err != nil {
+			return nil, err
+		}
+		s.maxSize = size
+	}
+	if v := q.Get("max-age"); v != "" {
+		age, err := time.ParseDuration(v)
+		if err != nil || age <= 0 {
+			return nil, fmt.Errorf("invalid max-age %s", v)
+		}
+		s.maxAge = age
+	}
+	switch q.Get("compress") {
+	case "", "none":
+	case "gzip":
+		s.compress = true
+	default:
+		return nil, fmt.Errorf("unsupported compress %s, use gzip or none", q.Get("compress"))
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) checkpointPath() string {
+	return filepath.Join(s.dir, sinkCheckpointFile)
+}
+
+func (s *fileSink) ext() string {
+	return sinkFileExtensions[s.output]
+}
+
+// open opens the file of the stream at base for the event with the given timestamp.
+// The file left open by a previous run is continued, the time range of its name tells the timestamp of its first event.
+func (s *fileSink) open(base string, timestamp int64) (*sinkFile, error) {
+	if err := os.MkdirAll(filepath.Dir(base), 0755); err != nil {
+		return nil, err
+	}
+	file := &sinkFile{base: base, opened: time.Now(), first: timestamp, last: timestamp}
+	path := base + "." + millisToTime(timestamp).UTC().Format(sinkTimeFormat) + s.ext()
+	matches, err := filepath.Glob(globEscape(base) + ".*" + s.ext())
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range matches {
+		r := strings.TrimSuffix(strings.TrimPrefix(m, base+"."), s.ext())
+		if t, err := time.Parse(sinkTimeFormat, r); err == nil && activeSinkFile.MatchString(r) {
+			path, file.first = m, t.UnixNano()/int64(time.Millisecond)
+			break
+		}
+	}
+	file.path = path
+	if err := s.reopen(file); err != nil {
+		return nil, err
+	}
+	s.log.Printf("sink: writing %s\n", path)
+	return file, nil
+}
+
+// reopen opens the file to append to it, making room under maxOpen first
+func (s *fileSink) reopen(file *sinkFile) error {
+	if err := s.closeLeastRecentlyWritten(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	file.f, file.size = f, info.Size()
+	file.buf = bufio.NewWriter(f)
+	file.writer = newEventWriter(file, s.output, s.formatter)
+	if w, ok := file.writer.(*jsonArrayEventWriter); ok && file.size > 0 {
+		// the array of a file written before, by this run or a previous one, is continued
+		w.started = true
+	}
+	return nil
+}
+
+// closeLeastRecentlyWritten closes the least recently written file if maxOpen files are open.
+// The file is not rotated: it stays in files and its next event reopens it.
+func (s *fileSink) closeLeastRecentlyWritten() error {
+	open := 0
+	var lru *sinkFile
+	for _, file := range s.files {
+		if file.f == nil {
+			continue
+		}
+		open++
+		if lru == nil || file.written.Before(lru.written) {
+			lru = file
+		}
+	}
+	if s.maxOpen <= 0 || open < s.maxOpen {
+		return nil
+	}
+	err := lru.buf.Flush()
+	if cerr := lru.f.Close(); err == nil {
+		err = cerr
+	}
+	lru.f, lru.buf, lru.writer = nil, nil, nil
+	return err
+}
+
+var globMeta = regexp.MustCompile(`[*?\[\\]`)
+
+func globEscape(path string) string {
+	return globMeta.ReplaceAllString(path, `\$0`)
+}
+
+// rotate closes the file and renames it after the time range of its events.
+// The file is closed even if rotate fails: the caller drops it and the next write reopens it.
+func (s *fileSink) rotate(file *sinkFile) error {
+	if file.f == nil {
+		if err := s.reopen(file); err != nil {
+			return err
+		}
+	}
+	if err := file.writer.close(); err != nil {
+		file.f.Close()
+		return err
+	}
+	if err := file.buf.Flush(); err != nil {
+		file.f.Close()
+		return err
+	}
+	if err := file.f.Close(); err != nil {
+		return err
+	}
+	first, last := millisToTime(file.first).UTC(), millisToTime(file.last).UTC()
+	if file.last < file.first {
+		last = first
+	}
+	path := fmt.Sprintf("%s.%s-%s%s", file.base, first.Format(sinkTimeFormat), last.Format(sinkTimeFormat), s.ext())
+	if s.compress {
+		path += ".gz"
+	}
+	// files rotated within the same millisecond get a sequence number
+	for i := 1; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+		path = fmt.Sprintf("%s.%s-%s.%d%s", file.base, first.Format(sinkTimeFormat), last.Format(sinkTimeFormat), i, s.ext())
+		if s.compress {
+			path += ".gz"
+		}
+	}
+	if s.compress {
+		if err := gzipFile(file.f.Name(), path); err != nil {
+			return err
+		}
+		if err := os.Remove(file.f.Name()); err != nil {
+			return err
+		}
+	} else if err := os.Rename(file.f.Name(), path); err != nil {
+		return err
+	}
+	s.log.Printf("sink: rotated %s\n", path)
+	return nil
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+func (s *fileSink) write(ev logEvent) error {
+	s.Lock()
+	defer s.Unlock()
+	base := filepath.Join(s.dir, fileName(ev.logGroup), fileName(derefString(ev.logEvent.LogStreamName)))
+	var timestamp int64
+	if ev.logEvent.Timestamp != nil {
+		timestamp = *ev.logEvent.Timestamp
+	}
+	file, ok := s.files[base]
+	if ok && (file.size >= s.maxSize || time.Since(file.opened) >= s.maxAge) {
+		err := s.rotate(file)
+		delete(s.files, base)
+		if err != nil {
+			return err
+		}
+		ok = false
+	}
+	if !ok {
+		var err error
+		if file, err = s.open(base, timestamp); err != nil {
+			return err
+		}
+		s.files[base] = file
+	} else if file.f == nil {
+		if err := s.reopen(file); err != nil {
+			return err
+		}
+	}
+	if err := file.writer.write(ev); err != nil {
+		return err
+	}
+	file.written = time.Now()
+	if timestamp > file.last {
+		file.last = timestamp
+	}
+	return nil
+}
+
+// flush rotates the files older than maxAge and flushes the others
+func (s *fileSink) flush() error {
+	s.Lock()
+	defer s.Unlock()
+	for base, file := range s.files {
+		if time.Since(file.opened) >= s.maxAge {
+			err := s.rotate(file)
+			delete(s.files, base)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if file.f == nil {
+			continue
+		}
+		if err := file.buf.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// close rotates all the files
+func (s *fileSink) close() error {
+	s.Lock()
+	defer s.Unlock()
+	var err error
+	for base, file := range s.files {
+		if rerr := s.rotate(file); err == nil {
+			err = rerr
+		}
+		delete(s.files, base)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fatih/color"
+)
+
+// templateEvent is the data available to the --format templates
+type templateEvent struct {
+	Timestamp     time.Time
+	IngestionTime time.Time
+	// Lag is the delay between the event timestamp and its ingestion time
+	Lag     time.Duration
+	Group   string
+	Stream  string
+	ID      string
+	Message string
+	// JSON holds the fields of the message when it is a JSON object
+	JSON jsonFields
+}
+
+// jsonFields maps the top level fields of a JSON object to their values as text: strings as they are,
+// null as an empty string, anything else as JSON. Missing fields render as empty strings.
+type jsonFields map[string]string
+
+func parseJSONFields(msg string) jsonFields {
+	fields := jsonFields{}
+	if !strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		return fields
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(msg), &data); err != nil {
+		return fields
+	}
+	for k, raw := range data {
+		var s string
+		switch {
+		case json.Unmarshal(raw, &s) == nil:
+			fields[k] = s
+		case string(raw) == "null":
+			fields[k] = ""
+		default:
+			fields[k] = string(raw)
+		}
+	}
+	return fields
+}
+
+func truncate(n int, s string) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	runes := []rune(s)
+	if n <= 3 {
+		return string(runes[:n])
+	}
+	return string(runes[:n-3]) + "..."
+}
+
+func colorFunc(c *color.Color) func(interface{}) string {
+	return func(v interface{}) string {
+		return c.Sprint(v)
+	}
+}
+
+func templateFuncs(h *highlighter) template.FuncMap {
+	funcs := template.FuncMap{
+		"color": func(name string, v interface{}) (string, error) {
+			c, err := parseColor(name)
+			if err != nil {
+				return "", err
+			}
+			return c.Sprint(v), nil
+		},
+		"fmtTime": func(layout string, t time.Time) string {
+			return t.Format(layout)
+		},
+		"truncate": truncate,
+		"upper":    strings.ToUpper,
+		"lower":    strings.ToLower,
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"default": func(def interface{}, v interface{}) interface{} {
+			if v == nil || v == "" {
+				return def
+			}
+			return v
+		},
+		"highlight": func(s string) string {
+			if h == nil {
+				return s
+			}
+			return h.apply(s)
+		},
+	}
+	for name, attr := range colorAttributes {
+		funcs[name] = colorFunc(color.New(attr))
+	}
+	return funcs
+}
+
+// newEventTemplate parses a --format template. Every executed template produces one line of output.
+// Missing JSON fields, e.g. {{.JSON.level}} for a message that is not JSON, are empty strings.
+func newEventTemplate(text string, h *highlighter) (*template.Template, error) {
+	tmpl, err := template.New("format").Funcs(templateFuncs(h)).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return tmpl, nil
+}
+
+func (f logEventFormatter) templateEvent(ev logEvent, msg string) templateEvent {
+	te := templateEvent{
+		Group:   ev.logGroup,
+		Stream:  derefString(ev.logEvent.LogStreamName),
+		ID:      derefString(ev.logEvent.EventId),
+		Message: msg,
+		JSON:    parseJSONFields(msg),
+	}
+	if ev.logEvent.Timestamp != nil {
+		te.Timestamp = millisToTime(*ev.logEvent.Timestamp)
+	}
+	if ev.logEvent.IngestionTime != nil {
+		te.IngestionTime = millisToTime(*ev.logEvent.IngestionTime)
+	}
+	if lag, ok := ingestionLag(ev.logEvent); ok {
+		te.Lag = lag
+	}
+	if loc := f.FormatConfig.Location; loc != nil {
+		te.Timestamp = te.Timestamp.In(loc)
+		te.IngestionTime = te.IngestionTime.In(loc)
+	}
+	return te
+}
+
+func (f logEventFormatter) executeTemplate(ev logEvent, msg string) string {
+	var b bytes.Buffer
+	if err := f.FormatConfig.Template.Execute(&b, f.templateEvent(ev, msg)); err != nil {
+		f.Log.Printf("Failed to execute format template: Error: %v\n", err)
+		return msg
+	}
+	return b.String()
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"os/signal"
 	"strings"
 
 	"github.com/fatih/color"
@@ -36,11 +35,3 @@ func newVersionMsg(currentVersion string, latestVersionChannel chan string) {
 		}
 	}
 }
-
-func versionCheckOnSigterm() {
-	//only way to avoid print of the signal: interrupt message
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-	os.Exit(0)
-}